@@ -0,0 +1,305 @@
+package rgm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/jwt"
+	"github.com/zicare/rgm/lockout"
+	"github.com/zicare/rgm/mail"
+	"github.com/zicare/rgm/msg"
+	"github.com/zicare/rgm/mysql"
+	"github.com/zicare/rgm/postgres"
+	"github.com/zicare/rgm/tps"
+)
+
+// App holds an application's state, that is its configuration,
+// messages, ACL, TPS and lockout controls, JWT revocation
+// registry and mailer, and manages its lifecycle.
+// Handlers and middleware obtain the state from the gin context,
+// set by Bind, falling back to the default one, check SetDefault.
+// This way differently configured Apps can coexist in one binary.
+// Start runs the background workers and Server, if set,
+// and Shutdown stops them, drains the App's email outbox and
+// closes the App's db handlers. SIGTERM and SIGINT trigger Shutdown,
+// bounded by the shutdown_timeout setting (30s if not set).
+type App struct {
+
+	// Optional, served on Start and gracefully shut down on Shutdown.
+	Server *http.Server
+
+	Config   *viper.Viper
+	Messages msg.Catalog
+	ACL      *ds.ACL
+	Limiter  *tps.Control
+	Revoked  *jwt.Registry
+
+	// Nil if lockout control is not configured.
+	Lockout *lockout.Control
+
+	// Renders emails into the App's outbox and sends them.
+	Mailer *mail.Mailer
+
+	// Optional, closed on Shutdown, i.e. the db handlers opened
+	// with mysql.Open for the App's tables, check mysql.IDB.
+	DBs []*sql.DB
+
+	// The data sources and factories the App was built with.
+	Opts InitOpts
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	done   chan struct{}
+	err    error
+}
+
+// New initializes an application with opts, as Init does,
+// without starting any background worker.
+// The first App becomes the default one, check SetDefault.
+func New(opts InitOpts) (*App, error) {
+
+	a, err := setup(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Config() == nil {
+		if err := a.SetDefault(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// SetDefault makes a's state the default one, used by the package
+// level functions, i.e. ds.Allowed or msg.Get, and by the data
+// sources whose tables don't implement mysql.IConfig, which read
+// the default configuration.
+func (a *App) SetDefault() error {
+
+	defaultApp = a
+
+	config.Set(a.Config)
+	msg.Set(a.Messages)
+	ds.SetDefaultACL(a.ACL)
+	tps.SetDefault(a.Limiter)
+	jwt.SetDefault(a.Revoked)
+	lockout.SetDefault(a.Lockout)
+	mail.SetDefault(a.Mailer)
+
+	return nil
+}
+
+// The App set by SetDefault, whose Shutdown
+// closes the default db handlers as well.
+var defaultApp *App
+
+// Bind returns a middleware setting a's state in the gin context,
+// for the handlers and middleware down the chain to use it.
+// Meant to be set on the router, i.e. gin.Engine.Use(a.Bind()).
+func (a *App) Bind() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		c.Set("Config", a.Config)
+		c.Set("Messages", a.Messages)
+		c.Set("ACL", a.ACL)
+		c.Set("TPS", a.Limiter)
+		c.Set("Revoked", a.Revoked)
+		c.Set("Lockout", a.Lockout)
+		c.Set("Mailer", a.Mailer)
+		c.Next()
+	}
+}
+
+// Start starts the background workers and Server, if set.
+// Server's address is bound before returning, its error, i.e.
+// the port being in use, is returned and nothing is started.
+func (a *App) Start() error {
+
+	var ln net.Listener
+	if a.Server != nil {
+		addr := a.Server.Addr
+		if addr == "" {
+			addr = ":http"
+		}
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+
+	a.workers(ctx)
+
+	if ln != nil {
+		go func() {
+			if err := a.Server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				glog.Error(err)
+				glog.Flush()
+			}
+		}()
+	}
+
+	// Shutdown on SIGTERM or SIGINT
+	go func() {
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+		defer signal.Stop(term)
+		select {
+		case <-term:
+			d := a.Config.GetDuration("shutdown_timeout")
+			if d <= 0 {
+				d = 30 * time.Second
+			}
+			sctx, scancel := context.WithTimeout(context.Background(), d)
+			defer scancel()
+			a.Shutdown(sctx)
+		case <-ctx.Done():
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown stops Server, if set, and the background workers,
+// makes an attempt to send the emails due in the App's outbox,
+// check mail.Mailer.Drain, and closes the db handlers in DBs.
+// The default mysql and postgres db handlers are closed
+// as well if a is the default App, check SetDefault.
+// If ctx is done before, the remaining steps are still taken,
+// and ctx's error is returned.
+// Only the first call takes effect, later calls return its result.
+func (a *App) Shutdown(ctx context.Context) error {
+
+	a.once.Do(func() {
+
+		var errs []error
+
+		if a.Server != nil {
+			if err := a.Server.Shutdown(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if a.cancel != nil {
+			a.cancel()
+		}
+		stopped := make(chan struct{})
+		go func() {
+			a.wg.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+		}
+
+		if err := a.Mailer.Drain(ctx); err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, db := range a.DBs {
+			if err := db.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if a == defaultApp {
+			if err := mysql.Close(); err != nil {
+				errs = append(errs, err)
+			}
+			if err := postgres.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		for _, err := range errs {
+			glog.Error(err)
+		}
+		glog.Flush()
+
+		if len(errs) > 0 {
+			a.err = errs[0]
+		}
+		close(a.done)
+	})
+
+	<-a.done
+	return a.err
+}
+
+// Done is closed once Shutdown completes, i.e. on SIGTERM.
+func (a *App) Done() <-chan struct{} {
+
+	return a.done
+}
+
+// Runs the background workers until ctx is done:
+// ACL hot reload, on SIGHUP and optionally every acl.reload_interval,
+// the revoked JWT and TPS registries clean up, and the email outbox
+// senders, check mail.Mailer.Work.
+// a.wg is done when all of them have returned.
+func (a *App) workers(ctx context.Context) {
+
+	run := func(w func(ctx context.Context)) {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			w(ctx)
+		}()
+	}
+
+	if (a.Opts.AclDSFactory != nil) && (a.Opts.Acl != nil) {
+		run(func(ctx context.Context) {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hup:
+				}
+				if _, err := a.ACL.Reload(); err != nil {
+					glog.Error(err)
+					glog.Flush()
+				}
+			}
+		})
+		if d := a.Config.GetDuration("acl.reload_interval"); d > 0 {
+			run(func(ctx context.Context) {
+				a.ACL.Watch(ctx, d)
+			})
+		}
+		if *a.Opts.Verbose {
+			fmt.Println("ACL hot reload... OK")
+		}
+	}
+
+	run(a.Revoked.CleanUp)
+
+	if a.Limiter != nil {
+		run(a.Limiter.CleanUp)
+	}
+
+	run(a.Mailer.Work)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 )
 
@@ -8,24 +9,56 @@ var config *viper.Viper
 
 // Init takes the environment, starts the viper
 // and loads the corresponding configuration file.
+// It becomes the default configuration, check Config.
 func Init(env string, dir string) (err error) {
 
-	config = viper.New()
-
-	config.SetConfigType("json")
-	config.SetConfigName(env)
-	config.AddConfigPath(dir + "/config/")
-
-	err = config.ReadInConfig()
+	c, err := New(env, dir)
 	if err != nil {
 		return err
 	}
 
+	config = c
 	return
 }
 
+// New takes the environment, starts a viper and loads
+// the corresponding configuration file, leaving the
+// default configuration untouched.
+func New(env string, dir string) (*viper.Viper, error) {
+
+	c := viper.New()
+
+	c.SetConfigType("json")
+	c.SetConfigName(env)
+	c.AddConfigPath(dir + "/config/")
+
+	if err := c.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 //Config returns the configuration struct
 func Config() *viper.Viper {
 
 	return config
 }
+
+// Set sets c as the default configuration.
+func Set(c *viper.Viper) {
+
+	config = c
+}
+
+// Of returns the configuration set in the "Config"
+// context key, i.e. by rgm.App, or the default one.
+func Of(c *gin.Context) *viper.Viper {
+
+	if v, exists := c.Get("Config"); !exists {
+		return config
+	} else if v, ok := v.(*viper.Viper); ok {
+		return v
+	}
+	return config
+}
@@ -0,0 +1,328 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/msg"
+)
+
+// AclController exported
+type AclController struct{}
+
+// Grant as received in query params.
+type grantQuery struct {
+	Role   string `form:"role" binding:"required"`
+	Route  string `form:"route" binding:"required"`
+	Method string `form:"method" binding:"required"`
+	Deny   bool   `form:"deny"`
+}
+
+func (q grantQuery) grant() ds.Grant {
+
+	return ds.Grant{Role: q.Role, Route: q.Route, Method: q.Method, Deny: q.Deny}
+}
+
+// Grant and time range as received in json body.
+type grantEntry struct {
+	Role   string    `json:"role" binding:"required"`
+	Route  string    `json:"route" binding:"required"`
+	Method string    `json:"method" binding:"required"`
+	Deny   bool      `json:"deny"`
+	From   time.Time `json:"from" binding:"required"`
+	To     time.Time `json:"to" binding:"required,gtfield=From"`
+}
+
+func (e grantEntry) grant() ds.Grant {
+
+	return ds.Grant{Role: e.Role, Route: e.Route, Method: e.Method, Deny: e.Deny}
+}
+
+func (e grantEntry) timeRange() ds.TimeRange {
+
+	return ds.TimeRange{From: e.From, To: e.To}
+}
+
+// Fetch lists all grants in IAclDataSource.
+func (ctrl AclController) Fetch(c *gin.Context, fn ds.AclDSFactory, d ds.IDataSource) {
+
+	if dsrc, err := fn(d); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if acl, err := dsrc.Fetch(); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			entries(acl),
+		)
+
+	}
+}
+
+// Post saves a new grant to IAclDataSource.
+// The grant takes effect immediately.
+func (ctrl AclController) Post(c *gin.Context, fn ds.AclDSFactory, d ds.IDataSource) {
+
+	e := new(grantEntry)
+
+	if dsrc, err := fn(d); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(e); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := dsrc.Insert(e.grant(), e.timeRange()); err != nil {
+
+		switch err.(type) {
+		case *ds.DuplicatedEntry:
+			c.JSON(
+				http.StatusConflict,
+				msg.Of(c).Get("43"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else {
+
+		ds.ACLOf(c).Put(e.grant(), e.timeRange())
+
+		c.JSON(
+			http.StatusCreated,
+			ds.AclEntry{Grant: e.grant(), TimeRange: e.timeRange()},
+		)
+
+	}
+}
+
+// Expire ends the validity of the grant matching the role,
+// route and method query params. It ends now unless
+// a different time is set with the at query param.
+// The change takes effect immediately.
+func (ctrl AclController) Expire(c *gin.Context, fn ds.AclDSFactory, d ds.IDataSource) {
+
+	q := &struct {
+		grantQuery
+		At time.Time `form:"at" time_format:"2006-01-02T15:04:05Z07:00"`
+	}{}
+
+	dsrc, err := fn(d)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+		return
+	}
+
+	if err := c.ShouldBindQuery(q); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+		return
+	}
+
+	if q.At.IsZero() {
+		q.At = time.Now()
+	}
+
+	if err := dsrc.Expire(q.grant(), q.At); err != nil {
+
+		switch err.(type) {
+		case *ds.NotFoundError:
+			c.JSON(
+				http.StatusNotFound,
+				msg.Of(c).Get("18"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else {
+
+		ds.ACLOf(c).Expire(q.grant(), q.At)
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("41").SetArgs(1),
+		)
+
+	}
+}
+
+// Delete removes the grant matching the role,
+// route and method query params.
+// The change takes effect immediately.
+func (ctrl AclController) Delete(c *gin.Context, fn ds.AclDSFactory, d ds.IDataSource) {
+
+	q := new(grantQuery)
+
+	if dsrc, err := fn(d); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := dsrc.Delete(q.grant()); err != nil {
+
+		switch err.(type) {
+		case *ds.NotFoundError:
+			c.JSON(
+				http.StatusNotFound,
+				msg.Of(c).Get("18"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else {
+
+		ds.ACLOf(c).Remove(q.grant())
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("29").SetArgs(1),
+		)
+
+	}
+}
+
+// Routes lists the routes registered in gin along
+// with their current grants in the in-memory acl,
+// including the pattern and deny grants matching them.
+// routes is meant to be gin.Engine.Routes().
+func (ctrl AclController) Routes(c *gin.Context, routes gin.RoutesInfo) {
+
+	type route struct {
+		Method string        `json:"method"`
+		Path   string        `json:"path"`
+		Grants []ds.AclEntry `json:"grants"`
+	}
+
+	var (
+		acl = entries(ds.ACLOf(c).Entries())
+		rs  = []route{}
+	)
+
+	for _, ri := range routes {
+		r := route{Method: ri.Method, Path: ri.Path, Grants: []ds.AclEntry{}}
+		for _, e := range acl {
+			if e.Matches(ri.Path, ri.Method) {
+				r.Grants = append(r.Grants, e)
+			}
+		}
+		rs = append(rs, r)
+	}
+
+	c.JSON(
+		http.StatusOK,
+		rs,
+	)
+}
+
+// Check tells if the role query param, or the roles it inherits from,
+// can call the route and method query params right now.
+func (ctrl AclController) Check(c *gin.Context) {
+
+	q := new(grantQuery)
+
+	if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{"role": q.Role, "route": q.Route, "method": q.Method, "allowed": ds.ACLOf(c).Allowed([]string{q.Role}, q.Route, q.Method)},
+		)
+
+	}
+}
+
+// Reload reloads the in-memory ACL and role hierarchy
+// from their data sources, and returns the ACL diff.
+func (ctrl AclController) Reload(c *gin.Context) {
+
+	if diff, err := ds.ACLOf(c).Reload(); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			diff,
+		)
+
+	}
+}
+
+// Returns acl as a slice sorted by role, route and method.
+func entries(acl ds.Acl) []ds.AclEntry {
+
+	e := []ds.AclEntry{}
+	for g, r := range acl {
+		e = append(e, ds.AclEntry{Grant: g, TimeRange: r})
+	}
+
+	sort.Slice(e, func(i, j int) bool {
+		if e[i].Role != e[j].Role {
+			return e[i].Role < e[j].Role
+		} else if e[i].Route != e[j].Route {
+			return e[i].Route < e[j].Route
+		} else if e[i].Method != e[j].Method {
+			return e[i].Method < e[j].Method
+		}
+		return !e[i].Deny && e[j].Deny
+	})
+
+	return e
+}
@@ -19,7 +19,7 @@ func (cc CrudController) Find(c *gin.Context, d ds.IDataSource) {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else if meta, data, err := d.Find(qo); err != nil {
@@ -28,17 +28,17 @@ func (cc CrudController) Find(c *gin.Context, d ds.IDataSource) {
 		case *ds.NotFoundError:
 			c.JSON(
 				http.StatusNotFound,
-				msg.Get("18"),
+				msg.Of(c).Get("18"),
 			)
 		case *ds.NotAllowedError:
 			c.JSON(
 				http.StatusUnauthorized,
-				msg.Get("11"),
+				msg.Of(c).Get("11"),
 			)
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 		}
 
@@ -64,7 +64,7 @@ func (cc CrudController) Fetch(c *gin.Context, d ds.IDataSource) {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else if meta, data, err := d.Fetch(qo); err != nil {
@@ -73,17 +73,17 @@ func (cc CrudController) Fetch(c *gin.Context, d ds.IDataSource) {
 		case *ds.NotFoundError:
 			c.JSON(
 				http.StatusNotFound,
-				msg.Get("18"),
+				msg.Of(c).Get("18"),
 			)
 		case *ds.NotAllowedError:
 			c.JSON(
 				http.StatusUnauthorized,
-				msg.Get("11"),
+				msg.Of(c).Get("11"),
 			)
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 		}
 
@@ -115,7 +115,7 @@ func (cc CrudController) Post(c *gin.Context, d ds.IDataSource) {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else if err := d.Insert(qo); err != nil {
@@ -124,7 +124,7 @@ func (cc CrudController) Post(c *gin.Context, d ds.IDataSource) {
 		case *ds.NotAllowedError:
 			c.JSON(
 				http.StatusUnauthorized,
-				msg.Get("11"),
+				msg.Of(c).Get("11"),
 			)
 		case *ds.ValidationErrors:
 			// Payload didn't pass Table's BeforeInsert validation
@@ -135,22 +135,22 @@ func (cc CrudController) Post(c *gin.Context, d ds.IDataSource) {
 		case *ds.ValidationError:
 			c.JSON(
 				http.StatusBadRequest,
-				msg.Get("19"),
+				msg.Of(c).Get("19"),
 			)
 		case *ds.DuplicatedEntry:
 			c.JSON(
 				http.StatusConflict,
-				msg.Get("43"),
+				msg.Of(c).Get("43"),
 			)
 		case *ds.ForeignKeyConstraint:
 			c.JSON(
 				http.StatusConflict,
-				msg.Get("42"),
+				msg.Of(c).Get("42"),
 			)
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 		}
 
@@ -178,7 +178,7 @@ func (cc CrudController) Update(c *gin.Context, d ds.IDataSource) {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else if rows, err := d.Update(qo); err != nil {
@@ -188,7 +188,7 @@ func (cc CrudController) Update(c *gin.Context, d ds.IDataSource) {
 			// User not authorized
 			c.JSON(
 				http.StatusUnauthorized,
-				msg.Get("11"),
+				msg.Of(c).Get("11"),
 			)
 		case *ds.ValidationErrors:
 			// Payload didn't pass Table's BeforeUpdate validation
@@ -199,19 +199,19 @@ func (cc CrudController) Update(c *gin.Context, d ds.IDataSource) {
 		case *ds.ValidationError:
 			c.JSON(
 				http.StatusBadRequest,
-				msg.Get("19"),
+				msg.Of(c).Get("19"),
 			)
 		case *ds.ForeignKeyConstraint:
 			// IDataSource found key constraints conflicts
 			c.JSON(
 				http.StatusConflict,
-				msg.Get("42"),
+				msg.Of(c).Get("42"),
 			)
 		case *ds.NotFoundError:
 			// IDataSource can't find the resource
 			c.JSON(
 				http.StatusNotFound,
-				msg.Get("18"),
+				msg.Of(c).Get("18"),
 			)
 		case *ds.UpdateError:
 			// IDataSource can't update the resource
@@ -222,7 +222,7 @@ func (cc CrudController) Update(c *gin.Context, d ds.IDataSource) {
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 		}
 
@@ -231,7 +231,7 @@ func (cc CrudController) Update(c *gin.Context, d ds.IDataSource) {
 		// resource updated
 		c.JSON(
 			http.StatusOK,
-			msg.Get("41").SetArgs(rows),
+			msg.Of(c).Get("41").SetArgs(rows),
 		)
 
 	}
@@ -244,7 +244,7 @@ func (cc CrudController) Delete(c *gin.Context, d ds.IDataSource) {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else if r, err := d.Delete(qo); err != nil {
@@ -253,22 +253,22 @@ func (cc CrudController) Delete(c *gin.Context, d ds.IDataSource) {
 		case *ds.NotAllowedError:
 			c.JSON(
 				http.StatusUnauthorized,
-				msg.Get("11"),
+				msg.Of(c).Get("11"),
 			)
 		case *ds.ForeignKeyConstraint:
 			c.JSON(
 				http.StatusConflict,
-				msg.Get("40"),
+				msg.Of(c).Get("40"),
 			)
 		case *ds.ValidationError:
 			c.JSON(
 				http.StatusConflict,
-				msg.Get("19"),
+				msg.Of(c).Get("19"),
 			)
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 		}
 
@@ -276,14 +276,14 @@ func (cc CrudController) Delete(c *gin.Context, d ds.IDataSource) {
 
 		c.JSON(
 			http.StatusNotFound,
-			msg.Get("18"),
+			msg.Of(c).Get("18"),
 		)
 
 	} else {
 
 		c.JSON(
 			http.StatusOK,
-			msg.Get("29").SetArgs(r),
+			msg.Of(c).Get("29").SetArgs(r),
 		)
 
 	}
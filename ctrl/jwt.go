@@ -1,37 +1,64 @@
 package ctrl
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/ds"
 	"github.com/zicare/rgm/jwt"
+	"github.com/zicare/rgm/lib"
 	"github.com/zicare/rgm/msg"
 )
 
 //JwtController exported
-type JwtController struct{}
+type JwtController struct {
 
-//Get exported
+	// Optional, returns the custom claims to attach to u's token.
+	// If nil, u.Claims are attached, if any.
+	Claims func(u ds.User) (interface{}, error)
+}
+
+// Get issues a JWT for the authenticated user.
+// Token scopes can be narrowed down to a subset of the user's
+// scopes with a space delimited scope query param.
 func (ctrl JwtController) Get(c *gin.Context) {
 
 	if u, ok := c.Get("User"); !ok {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("5"),
+			msg.Of(c).Get("5"),
 		)
 
 	} else if u, ok := u.(ds.User); !ok {
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("5"),
+			msg.Of(c).Get("5"),
+		)
+
+	} else if claims, err := ctrl.claims(u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if j, err := jwt.JWTFactoryWith(config.Of(c), u.UID, u.Role, u.Type, u.TPS, u.From, u.To).
+		SetRoles(u.Roles...).
+		SetScopes(ctrl.scopes(c, u)...).
+		SetClaims(claims); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else {
 
-		j := jwt.JWTFactory(u.UID, u.Role, u.Type, u.TPS, u.From, u.To)
 		c.JSON(
 			http.StatusOK,
 			gin.H{"header": j.GetHeader(), "payload": j.GetPayload(), "token": j.ToString()},
@@ -40,3 +67,21 @@ func (ctrl JwtController) Get(c *gin.Context) {
 	}
 
 }
+
+func (ctrl JwtController) claims(u ds.User) (interface{}, error) {
+
+	if ctrl.Claims != nil {
+		return ctrl.Claims(u)
+	} else if len(u.Claims) > 0 {
+		return u.Claims, nil
+	}
+	return nil, nil
+}
+
+func (ctrl JwtController) scopes(c *gin.Context, u ds.User) []string {
+
+	if scope, ok := c.GetQuery("scope"); ok {
+		return lib.Intersect(strings.Fields(scope), u.Scopes)
+	}
+	return u.Scopes
+}
@@ -0,0 +1,48 @@
+package ctrl
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/lockout"
+	"github.com/zicare/rgm/msg"
+)
+
+// LockoutController exported
+// Admin API to lift lockouts, i.e. of the IP of an office
+// or proxy shared by many users.
+type LockoutController struct{}
+
+// Unlock lifts the lockout of the username in the usr query
+// param and of the client IP in the ip query param, forgetting
+// their failed attempts. At least one of them must be set.
+func (ctrl LockoutController) Unlock(c *gin.Context) {
+
+	q := &struct {
+		Usr string `form:"usr" binding:"required_without=IP"`
+		IP  string `form:"ip" binding:"omitempty,ip"`
+	}{}
+
+	if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else {
+
+		if q.Usr != "" {
+			lockout.Of(c).Reset(lockout.UsrKey(q.Usr))
+		}
+		if q.IP != "" {
+			lockout.Of(c).Reset(lockout.IPKey(q.IP))
+		}
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("58"),
+		)
+
+	}
+}
@@ -0,0 +1,267 @@
+package ctrl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/mail"
+	"github.com/zicare/rgm/msg"
+	"github.com/zicare/rgm/mw"
+	"github.com/zicare/rgm/tps"
+)
+
+// MagicController exported
+// Offers passwordless sign-in through links sent by email.
+// Links carry a signed token that expires after magic.ttl (15m if not set),
+// and is single use unless magic.single_use is set to false.
+// Up to magic.rate links (3 if not set) can be requested per email
+// every magic.window (1h if not set).
+// Its pins are kept apart from the password reset ones, the pin
+// IDataSource must have a purpose tagged field, check ds.IPinPurpose.
+type MagicController struct {
+
+	// Issues the JWT once the link is exchanged.
+	Token JwtController
+}
+
+// Post sends a sign-in link to the requesting user by email.
+// The link is magic.url with the token query param appended,
+// and is sent using the magic.tpl template.
+func (ctrl MagicController) Post(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSource, u ds.IDataSource) {
+
+	d := &struct {
+		Email string `json:"usr" binding:"required,email"`
+	}{}
+
+	if dsrc, err := magicPins(fn, p, u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(d); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if until := magicLimited(c, d.Email); until != nil {
+
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(*until).Seconds())+1))
+		c.JSON(
+			http.StatusTooManyRequests,
+			msg.Of(c).Get("68"),
+		)
+
+	} else if pin, err := dsrc.Post(d.Email); err != nil {
+
+		switch err.(type) {
+		case *ds.InvalidCredentials, *ds.ExpiredCredentials:
+			c.JSON(
+				http.StatusAccepted,
+				msg.Of(c).Get("67"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else if err := pin.SendLink(mail.Of(c), config.Of(c).GetString("magic.url") + "?token=" + url.QueryEscape(magicToken(config.Of(c), pin.Email, pin.Code, time.Now().Add(magicTTL(config.Of(c)))))); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusAccepted,
+			msg.Of(c).Get("67"),
+		)
+
+	}
+}
+
+// Get exchanges the token query param of a sign-in link for a JWT.
+// Users with two-factor authentication enabled must pass it too,
+// failed attempts being accounted for lockout, check mw.Verify2FA.
+// Single use links are consumed only once it passes.
+func (ctrl MagicController) Get(c *gin.Context, fn ds.PinDSFactory, ufn ds.UserDSFactory, p ds.IDataSource, u ds.IDataSource, crypto lib.ICrypto) {
+
+	if pdsrc, err := magicPins(fn, p, u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if udsrc, err := ufn(u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if email, code, ok := magicVerify(config.Of(c), c.Query("token")); !ok {
+
+		c.JSON(
+			http.StatusUnauthorized,
+			msg.Of(c).Get("69"),
+		)
+
+	} else if usr, err := udsrc.Get(email); err != nil {
+
+		switch err.(type) {
+		case *ds.InvalidCredentials, *ds.ExpiredCredentials:
+			c.JSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("69"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else if !mw.Verify2FA(c, udsrc, crypto, usr) {
+
+		// aborted by Verify2FA, the link is left unused
+
+	} else if err := magicUse(config.Of(c), pdsrc, email, code); err != nil {
+
+		switch err.(type) {
+		case *ds.InvalidCredentials, *ds.ExpiredCredentials, *ds.InvalidPinError, *ds.ExpiredPinError, *ds.PinAttemptsError:
+			c.JSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("69"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else {
+
+		c.Set("User", usr)
+		ctrl.Token.Get(c)
+
+	}
+}
+
+// Returns the pin data source for sign-in links, keeping their
+// pins apart from the password reset ones, check ds.IPinPurpose.
+func magicPins(fn ds.PinDSFactory, p ds.IDataSource, u ds.IDataSource) (ds.IPinDataSource, error) {
+
+	if dsrc, err := fn(p, u); err != nil {
+		return dsrc, err
+	} else if pp, ok := dsrc.(ds.IPinPurpose); ok {
+		return pp.For("magic")
+	} else {
+		err := new(ds.TagError)
+		err.Copy(msg.Get("2").SetArgs("Pin"))
+		return dsrc, err
+	}
+}
+
+func magicTTL(cf *viper.Viper) time.Duration {
+
+	if ttl := cf.GetDuration("magic.ttl"); ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+func magicSingleUse(cf *viper.Viper) bool {
+
+	return !cf.IsSet("magic.single_use") || cf.GetBool("magic.single_use")
+}
+
+// Consumes the pin carried by a sign-in link, if single use.
+func magicUse(cf *viper.Viper, pdsrc ds.IPinDataSource, email, code string) error {
+
+	if !magicSingleUse(cf) {
+		return nil
+	}
+	return pdsrc.Verify(email, code)
+}
+
+// Accounts for a sign-in link request for email in the App's
+// TPS control. Returns the time the window ends if it exceeds magic.rate.
+func magicLimited(c *gin.Context, email string) *time.Time {
+
+	cf := config.Of(c)
+	rate := cf.GetInt("magic.rate")
+	if rate <= 0 {
+		rate = 3
+	}
+	window := cf.GetDuration("magic.window")
+	if window <= 0 {
+		window = time.Hour
+	}
+
+	return tps.Of(c).Limit(tps.Key("magic", strings.ToLower(email)), rate, window)
+}
+
+// Returns a token carrying email, code and exp,
+// signed with the hmac_key setting.
+func magicToken(cf *viper.Viper, email, code string, exp time.Time) string {
+
+	payload := email + "\n" + code + "\n" + strconv.FormatInt(exp.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + magicSign(cf, payload)
+}
+
+// Returns the email and code carried by token,
+// provided it's properly signed and not expired.
+func magicVerify(cf *viper.Viper, token string) (email, code string, ok bool) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", false
+	}
+
+	payload := string(b)
+	if !hmac.Equal([]byte(magicSign(cf, payload)), []byte(parts[1])) {
+		return "", "", false
+	}
+
+	f := strings.Split(payload, "\n")
+	if len(f) != 3 {
+		return "", "", false
+	} else if exp, err := strconv.ParseInt(f[2], 10, 64); err != nil || time.Now().Unix() > exp {
+		return "", "", false
+	}
+
+	return f[0], f[1], true
+}
+
+func magicSign(cf *viper.Viper, payload string) string {
+
+	h := hmac.New(sha256.New, []byte(cf.GetString("hmac_key")))
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,112 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/mail"
+	"github.com/zicare/rgm/msg"
+)
+
+// MailController exported
+// Admin API to inspect the email outbox and resend failed emails.
+type MailController struct{}
+
+// Fetch lists the outbox entries with the status query param,
+// the dead ones if not set, the latest first. Entries are listed
+// with their email headers only, check mail.Entry.Summary.
+func (ctrl MailController) Fetch(c *gin.Context) {
+
+	q := &struct {
+		Status string `form:"status" binding:"omitempty,oneof=pending sent dead"`
+	}{}
+
+	if err := c.ShouldBindQuery(q); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+		return
+	}
+
+	if q.Status == "" {
+		q.Status = string(mail.Dead)
+	}
+
+	ctrl.list(c, mail.Status(q.Status))
+}
+
+// Resend sets the dead entry matching the id query param
+// pending again, to be sent right away.
+func (ctrl MailController) Resend(c *gin.Context) {
+
+	q := &struct {
+		ID string `form:"id" binding:"required"`
+	}{}
+
+	if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := mail.Of(c).Resend(q.ID); err != nil {
+
+		switch err.(type) {
+		case *mail.NotFoundError:
+			c.JSON(
+				http.StatusNotFound,
+				msg.Of(c).Get("18"),
+			)
+		case *mail.NotDeadError:
+			c.JSON(
+				http.StatusConflict,
+				msg.Of(c).Get("76"),
+			)
+		case *mail.RedactedError:
+			c.JSON(
+				http.StatusConflict,
+				msg.Of(c).Get("80"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("75"),
+		)
+
+	}
+}
+
+// Responds with the outbox entries with status s.
+func (ctrl MailController) list(c *gin.Context, s mail.Status) {
+
+	if l, err := mail.Of(c).List(s); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		for i, e := range l {
+			l[i] = e.Summary()
+		}
+
+		c.JSON(
+			http.StatusOK,
+			l,
+		)
+
+	}
+}
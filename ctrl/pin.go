@@ -5,8 +5,12 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/jwt"
 	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/mail"
+	"github.com/zicare/rgm/lockout"
 	"github.com/zicare/rgm/msg"
 )
 
@@ -24,7 +28,7 @@ func (ctrl PinController) Post(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSou
 
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
 
 	} else if err := c.ShouldBindJSON(d); err != nil {
@@ -40,22 +44,27 @@ func (ctrl PinController) Post(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSou
 		case *ds.InvalidCredentials, *ds.ExpiredCredentials:
 			c.JSON(
 				http.StatusAccepted,
-				msg.Get("33"),
+				msg.Of(c).Get("33"),
 			)
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 		}
 
-	} else {
+	} else if err := p.Send(mail.Of(c)); err != nil {
 
-		p.Send()
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
 
 		c.JSON(
 			http.StatusAccepted,
-			msg.Get("33"),
+			msg.Of(c).Get("33"),
 		)
 
 	}
@@ -69,11 +78,14 @@ func (ctrl PinController) Patch(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSo
 	if err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
-			msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 		)
+		return
+	} else if r, ok := dsrc.(ds.IPinRevoker); ok {
+		dsrc = r.Revoking(jwt.Of(c))
 	}
 
-	pr := ds.PatchReceiver()
+	pr := ds.PatchReceiverWith(config.Of(c))
 	if err := c.ShouldBindJSON(pr); err != nil {
 		c.JSON(
 			http.StatusBadRequest,
@@ -88,17 +100,21 @@ func (ctrl PinController) Patch(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSo
 		ml := []msg.Message{}
 		switch err.(type) {
 		case *ds.InvalidCredentials:
-			ml = append(ml, msg.Get("4").SetField("usr"))
+			ml = append(ml, msg.Of(c).Get("4").SetField("usr"))
 		case *ds.ExpiredCredentials:
-			ml = append(ml, msg.Get("6").SetField("usr"))
+			ml = append(ml, msg.Of(c).Get("6").SetField("usr"))
 		case *ds.InvalidPinError:
-			ml = append(ml, msg.Get("36").SetField("pin"))
+			ml = append(ml, msg.Of(c).Get("36").SetField("pin"))
 		case *ds.ExpiredPinError:
-			ml = append(ml, msg.Get("39").SetField("pin"))
+			ml = append(ml, msg.Of(c).Get("39").SetField("pin"))
+		case *ds.PinAttemptsError:
+			ml = append(ml, msg.Of(c).Get("66").SetField("pin"))
+		case *ds.ReusedPassword:
+			ml = append(ml, msg.Of(c).Get("65").SetField("pwd"))
 		default:
 			c.JSON(
 				http.StatusInternalServerError,
-				msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 			)
 			return
 		}
@@ -111,8 +127,71 @@ func (ctrl PinController) Patch(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSo
 
 		c.JSON(
 			http.StatusOK,
-			msg.Get("38"),
+			msg.Of(c).Get("38"),
 		)
 	}
 
 }
+
+// Unlock lifts the lockout of a user locked out after too many
+// failed authentication attempts, provided a valid pin, and the
+// lockout of the client IP the request comes from.
+// The pin is requested through Post as for password resets.
+func (ctrl PinController) Unlock(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSource, u ds.IDataSource) {
+
+	d := &struct {
+		Pin   string `json:"pin" binding:"required"`
+		Email string `json:"usr" binding:"required,email"`
+	}{}
+
+	if dsrc, err := fn(p, u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(d); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := dsrc.Verify(d.Email, d.Pin); err != nil {
+
+		ml := []msg.Message{}
+		switch err.(type) {
+		case *ds.InvalidCredentials:
+			ml = append(ml, msg.Of(c).Get("4").SetField("usr"))
+		case *ds.ExpiredCredentials:
+			ml = append(ml, msg.Of(c).Get("6").SetField("usr"))
+		case *ds.InvalidPinError:
+			ml = append(ml, msg.Of(c).Get("36").SetField("pin"))
+		case *ds.ExpiredPinError:
+			ml = append(ml, msg.Of(c).Get("39").SetField("pin"))
+		case *ds.PinAttemptsError:
+			ml = append(ml, msg.Of(c).Get("66").SetField("pin"))
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+			return
+		}
+		c.JSON(
+			http.StatusBadRequest,
+			ml,
+		)
+
+	} else {
+
+		lockout.Of(c).Reset(lockout.UsrKey(d.Email), lockout.IPKey(c.ClientIP()))
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("58"),
+		)
+
+	}
+}
@@ -0,0 +1,93 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/jwt"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/msg"
+)
+
+// PwdController exported
+// Meant to be routed behind authentication.
+type PwdController struct{}
+
+// Patch changes the authenticated user's password, provided its
+// current one. Passwords are validated with account.pwd_validation,
+// and can't be any of the last account.pwd_history ones.
+// JWTs issued before the change are revoked.
+func (ctrl PwdController) Patch(c *gin.Context, fn ds.UserDSFactory, u ds.IDataSource, crypto lib.ICrypto) {
+
+	var (
+		uid = ds.UID(c)
+		pr  = ds.PwdChangeReceiverWith(config.Of(c))
+	)
+
+	if uid == "" {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if dsrc, err := fn(u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(pr); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := changePwd(c, dsrc, uid, pr, crypto); err != nil {
+
+		ml := []msg.Message{}
+		switch err.(type) {
+		case *ds.InvalidCredentials:
+			ml = append(ml, msg.Of(c).Get("4").SetField("pwd_current"))
+		case *ds.ExpiredCredentials:
+			ml = append(ml, msg.Of(c).Get("6").SetField("pwd_current"))
+		case *ds.ReusedPassword:
+			ml = append(ml, msg.Of(c).Get("65").SetField("pwd"))
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+			return
+		}
+		c.JSON(
+			http.StatusBadRequest,
+			ml,
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("38"),
+		)
+
+	}
+}
+
+// Changes uid's password as bound in pr, revoking its JWTs
+// in the App's registry, check ds.IUserRevoker.
+func changePwd(c *gin.Context, dsrc ds.IUserDataSource, uid string, pr interface{}, crypto lib.ICrypto) error {
+
+	if r, ok := dsrc.(ds.IUserRevoker); ok {
+		dsrc = r.Revoking(jwt.Of(c))
+	}
+
+	change := ds.PwdChangeDecoder(pr)
+	return dsrc.ChangePwd(uid, change.Current, change.Password, crypto)
+}
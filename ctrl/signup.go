@@ -0,0 +1,227 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/mail"
+	"github.com/zicare/rgm/msg"
+)
+
+// SignupController exported
+type SignupController struct{}
+
+// Post creates a pending user in u, the user IDataSource, bound from
+// the request body, and sends it a pin by email to confirm the account
+// with Patch. The account remains inactive until then.
+// Passwords are validated with account.pwd_validation, and role and
+// tps are set from account.signup_role and account.signup_tps.
+// Only usr, pwd and the profile fields in account.signup_fields
+// are taken from the request body, check ds.Pend.
+func (ctrl SignupController) Post(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSource, u ds.IDataSource, crypto lib.ICrypto) {
+
+	sr := ds.SignupReceiverWith(config.Of(c))
+
+	dsrc, err := fn(p, u)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+		return
+	}
+
+	if err := c.ShouldBindBodyWith(sr, binding.JSON); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+		return
+	} else if err := c.ShouldBindBodyWith(u, binding.JSON); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+		return
+	}
+
+	d := ds.PatchDecoder(sr)
+	if err := ds.PendWith(config.Of(c), u, d.Password, crypto); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+		return
+	}
+
+	if qo, err := ds.QOFactory(c, u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := u.Insert(qo); err != nil {
+
+		switch err.(type) {
+		case *ds.ValidationErrors:
+			// Payload didn't pass Table's BeforeInsert validation
+			c.JSON(
+				http.StatusBadRequest,
+				err,
+			)
+		case *ds.ValidationError:
+			c.JSON(
+				http.StatusBadRequest,
+				msg.Of(c).Get("19"),
+			)
+		case *ds.DuplicatedEntry:
+			c.JSON(
+				http.StatusConflict,
+				msg.Of(c).Get("43"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else if p, err := dsrc.Issue(d.Email); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := p.Send(mail.Of(c)); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusCreated,
+			msg.Of(c).Get("62"),
+		)
+
+	}
+}
+
+// Put sends a new confirmation pin to a pending user.
+func (ctrl SignupController) Put(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSource, u ds.IDataSource) {
+
+	d := &struct {
+		Email string `json:"usr" binding:"required,email"`
+	}{}
+
+	if dsrc, err := fn(p, u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(d); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if p, err := dsrc.Issue(d.Email); err != nil {
+
+		switch err.(type) {
+		case *ds.InvalidCredentials:
+			c.JSON(
+				http.StatusAccepted,
+				msg.Of(c).Get("33"),
+			)
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else if err := p.Send(mail.Of(c)); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusAccepted,
+			msg.Of(c).Get("33"),
+		)
+
+	}
+}
+
+// Patch confirms a pending user provided the pin sent to it,
+// activating the account.
+func (ctrl SignupController) Patch(c *gin.Context, fn ds.PinDSFactory, p ds.IDataSource, u ds.IDataSource) {
+
+	d := &struct {
+		Pin   string `json:"pin" binding:"required"`
+		Email string `json:"usr" binding:"required,email"`
+	}{}
+
+	if dsrc, err := fn(p, u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(d); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := dsrc.Activate(d.Email, d.Pin); err != nil {
+
+		ml := []msg.Message{}
+		switch err.(type) {
+		case *ds.InvalidCredentials:
+			ml = append(ml, msg.Of(c).Get("4").SetField("usr"))
+		case *ds.InvalidPinError:
+			ml = append(ml, msg.Of(c).Get("36").SetField("pin"))
+		case *ds.ExpiredPinError:
+			ml = append(ml, msg.Of(c).Get("39").SetField("pin"))
+		case *ds.PinAttemptsError:
+			ml = append(ml, msg.Of(c).Get("66").SetField("pin"))
+		default:
+			c.JSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+			return
+		}
+		c.JSON(
+			http.StatusBadRequest,
+			ml,
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("63"),
+		)
+
+	}
+}
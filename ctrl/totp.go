@@ -0,0 +1,172 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/msg"
+)
+
+// TotpController exported
+// Meant to be routed behind basic authentication,
+// i.e. with rgm.BHC.
+type TotpController struct{}
+
+// Get returns a new TOTP secret for the authenticated user along
+// its provisioning URI. The secret is not saved until confirmed with Put.
+func (ctrl TotpController) Get(c *gin.Context) {
+
+	if u, ok := c.Get("User"); !ok {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if u, ok := u.(ds.User); !ok {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if secret, err := lib.TOTPSecret(); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{"secret": secret, "uri": lib.TOTPURIWith(config.Of(c), secret, u.Usr)},
+		)
+
+	}
+}
+
+// Put enables two-factor authentication for the authenticated user.
+// It expects the secret obtained with Get and a valid code generated
+// with it, and returns the recovery codes, which are saved hashed
+// and won't be shown again. totp.recovery_codes sets how many.
+func (ctrl TotpController) Put(c *gin.Context, fn ds.UserDSFactory, u ds.IDataSource, crypto lib.ICrypto) {
+
+	d := &struct {
+		Secret string `json:"secret" binding:"required"`
+		OTP    string `json:"otp" binding:"required"`
+	}{}
+
+	if usr, ok := c.Get("User"); !ok {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if usr, ok := usr.(ds.User); !ok {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if dsrc, err := fn(u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := c.ShouldBindJSON(d); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if !lib.TOTPVerifyWith(config.Of(c), d.Secret, d.OTP) {
+
+		c.JSON(
+			http.StatusBadRequest,
+			[]msg.Message{msg.Of(c).Get("60").SetField("otp")},
+		)
+
+	} else if codes, err := lib.RecoveryCodes(config.Of(c).GetInt("totp.recovery_codes")); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := dsrc.PatchTOTP(usr.Usr, d.Secret, hashed(codes, crypto)); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{"recovery": codes},
+		)
+
+	}
+}
+
+// Delete disables two-factor authentication for the authenticated user.
+func (ctrl TotpController) Delete(c *gin.Context, fn ds.UserDSFactory, u ds.IDataSource) {
+
+	if usr, ok := c.Get("User"); !ok {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if usr, ok := usr.(ds.User); !ok {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("5"),
+		)
+
+	} else if dsrc, err := fn(u); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if err := dsrc.PatchTOTP(usr.Usr, "", nil); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("61"),
+		)
+
+	}
+}
+
+func hashed(codes []string, crypto lib.ICrypto) []string {
+
+	h := make([]string, len(codes))
+	for i, code := range codes {
+		h[i] = crypto.Encode(code)
+	}
+	return h
+}
@@ -0,0 +1,204 @@
+package ctrl
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/msg"
+	"github.com/zicare/rgm/tps"
+)
+
+// TpsController exported
+// Admin API to inspect and adjust TPS control.
+// Changes apply to the live limiter.
+type TpsController struct{}
+
+// Key as received in query params. Keys are listed by Fetch,
+// users' ones being made of their type and uid, check tps.Key.
+type tpsQuery struct {
+	Key string `form:"key" binding:"required"`
+}
+
+// Fetch lists the users and client IPs currently accounted
+// for, with their measured TPS and penalty, if any.
+// Only the penalized ones are listed with penalized=true.
+func (ctrl TpsController) Fetch(c *gin.Context) {
+
+	type entry struct {
+		Key        string     `json:"key"`
+		TPS        float32    `json:"tps"`
+		Last       time.Time  `json:"last"`
+		Penalty    *time.Time `json:"penalty"`
+		Quota      float32    `json:"quota,omitempty"`
+		QuotaUntil *time.Time `json:"quota_until,omitempty"`
+	}
+
+	if tps.Of(c) == nil {
+
+		c.JSON(
+			http.StatusOK,
+			[]entry{},
+		)
+
+	} else if m, err := tps.Of(c).List(); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		var (
+			now       = time.Now()
+			penalized = c.Query("penalized") == "true"
+			e         = []entry{}
+		)
+
+		for k, s := range m {
+			p := s.Penalty(now)
+			if penalized && p == nil {
+				continue
+			}
+			e = append(e, entry{Key: k, TPS: s.TPS, Last: s.Ts, Penalty: p, Quota: s.Quota, QuotaUntil: s.QuotaUntil})
+		}
+
+		sort.Slice(e, func(i, j int) bool {
+			return e[i].Key < e[j].Key
+		})
+
+		c.JSON(
+			http.StatusOK,
+			e,
+		)
+
+	}
+}
+
+// Clear lifts the penalty of the key query param,
+// resetting its request record and its rules' ones.
+func (ctrl TpsController) Clear(c *gin.Context) {
+
+	q := new(tpsQuery)
+
+	if tps.Of(c) == nil {
+
+		c.JSON(
+			http.StatusNotFound,
+			msg.Of(c).Get("18"),
+		)
+
+	} else if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if found, err := tps.Of(c).Clear(q.Key); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else if !found {
+
+		c.JSON(
+			http.StatusNotFound,
+			msg.Of(c).Get("18"),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("72"),
+		)
+
+	}
+}
+
+// Extend voids access for the key query param
+// until the until query param.
+func (ctrl TpsController) Extend(c *gin.Context) {
+
+	q := &struct {
+		tpsQuery
+		Until time.Time `form:"until" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+	}{}
+
+	if tps.Of(c) == nil {
+
+		c.JSON(
+			http.StatusNotFound,
+			msg.Of(c).Get("18"),
+		)
+
+	} else if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := tps.Of(c).Penalize(q.Key, &q.Until); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("74").SetArgs(q.Until.Format(time.RFC3339)),
+		)
+
+	}
+}
+
+// Override sets the tps query param as the TPS quota
+// of the key query param until the until query param.
+func (ctrl TpsController) Override(c *gin.Context) {
+
+	q := &struct {
+		tpsQuery
+		TPS   float32   `form:"tps" binding:"required,gt=0"`
+		Until time.Time `form:"until" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+	}{}
+
+	if tps.Of(c) == nil {
+
+		c.JSON(
+			http.StatusNotFound,
+			msg.Of(c).Get("18"),
+		)
+
+	} else if err := c.ShouldBindQuery(q); err != nil {
+
+		c.JSON(
+			http.StatusBadRequest,
+			msg.ValidationErrors(err),
+		)
+
+	} else if err := tps.Of(c).Override(q.Key, q.TPS, q.Until); err != nil {
+
+		c.JSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		c.JSON(
+			http.StatusOK,
+			msg.Of(c).Get("73").SetArgs(q.TPS, q.Until.Format(time.RFC3339)),
+		)
+
+	}
+}
@@ -1,13 +1,80 @@
 package ds
 
 import (
+	"context"
+	"sync"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/zicare/rgm/lib"
 )
 
-// In-memory access control list.
-// acl maps each grant to a time range.
+// In-memory access control list and role hierarchy.
 // Helps speed up Authorization middleware.
-var acl Acl
+// ACL's are safe for concurrent use.
+type ACL struct {
+
+	// Maps each grant to a time range.
+	acl Acl
+
+	// Grants in acl with wildcard routes or methods,
+	// and deny grants. These can't be looked up by key.
+	// Rebuilt by index whenever acl changes.
+	patterns []Grant
+
+	// Maps each role to the parent roles it inherits grants from.
+	hierarchy Hierarchy
+
+	// Attribute based access control policies.
+	// Evaluated by Authorization middleware on top of the acl grants.
+	policies []Policy
+
+	// Guards acl, patterns, hierarchy and policies, which can be swapped
+	// by Reload while being read by Authorization middleware.
+	mu sync.RWMutex
+
+	// Loaders set by Init and InitRoles, used by Reload.
+	// Also guarded by mu.
+	aclLoader  func() (Acl, error)
+	roleLoader func() (Hierarchy, error)
+
+	// Serializes Reload calls.
+	reloading sync.Mutex
+}
+
+// The default ACL, check DefaultACL.
+var acl = NewACL()
+
+// NewACL returns an empty ACL.
+func NewACL() *ACL {
+
+	return &ACL{acl: Acl{}}
+}
+
+// DefaultACL returns the ACL the package level functions work on.
+func DefaultACL() *ACL {
+
+	return acl
+}
+
+// SetDefaultACL sets a as the ACL the package level functions work on.
+func SetDefaultACL(a *ACL) {
+
+	acl = a
+}
+
+// ACLOf returns the ACL set in the "ACL" context key,
+// i.e. by rgm.App, or the default one.
+func ACLOf(c *gin.Context) *ACL {
+
+	if v, exists := c.Get("ACL"); !exists {
+		return acl
+	} else if v, ok := v.(*ACL); ok {
+		return v
+	}
+	return acl
+}
 
 // Acl exported
 type Acl map[Grant]TimeRange
@@ -19,17 +86,47 @@ type TimeRange struct {
 }
 
 // Grant exported
+// Route can be a pattern, check Match, and Method can be "*".
+// A Deny grant revokes access to its roles, overriding any other grant.
 type Grant struct {
 	Role   string `json:"role"`
 	Route  string `json:"route"`
 	Method string `json:"method"`
+	Deny   bool   `json:"deny"`
+}
+
+// AclEntry is a Grant along its validity TimeRange.
+type AclEntry struct {
+	Grant
+	TimeRange
+}
+
+// AclDiff lists the grants that changed on Reload.
+type AclDiff struct {
+	Added   []Grant `json:"added"`
+	Removed []Grant `json:"removed"`
+	Changed []Grant `json:"changed"`
 }
 
-// Validates if g Grant exists and is valid at the time.
+// Validates if g Grant exists and is valid at the time
+// in the default ACL.
 func (g Grant) Valid() bool {
 
-	now := time.Now()
-	if r, ok := acl[g]; !ok {
+	return acl.Valid(g)
+}
+
+// Valid validates if g Grant exists and is valid at the time.
+func (a *ACL) Valid(g Grant) bool {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.valid(g, time.Now())
+}
+
+func (a *ACL) valid(g Grant, now time.Time) bool {
+
+	if r, ok := a.acl[g]; !ok {
 		return false
 	} else if now.Before(r.From) || now.After(r.To) {
 		return false
@@ -37,23 +134,289 @@ func (g Grant) Valid() bool {
 	return true
 }
 
+// Allowed checks rs, route and method against the default ACL.
+func Allowed(rs []string, route, method string) bool {
+
+	return acl.Allowed(rs, route, method)
+}
+
+// Allowed validates if any of the roles, or the roles they inherit from,
+// has a valid grant for route and method, either exact or through
+// a pattern, and none of them has a valid deny grant matching.
+func (a *ACL) Allowed(rs []string, route, method string) bool {
+
+	granted, denied := a.lookup(rs, route, method)
+	return granted && !denied
+}
+
+// Reports whether any of the roles, or the roles they inherit from,
+// has a valid grant for route and method, and apart from it, whether
+// any of them has a valid deny grant matching.
+func (a *ACL) lookup(rs []string, route, method string) (granted, denied bool) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var (
+		now   = time.Now()
+		roles = a.hierarchy.Effective(rs...)
+	)
+
+	for _, role := range roles {
+		if a.valid(Grant{Role: role, Route: route, Method: method}, now) {
+			granted = true
+			break
+		}
+	}
+
+	for _, g := range a.patterns {
+		if !lib.Contains(roles, g.Role) || !g.Matches(route, method) || !a.valid(g, now) {
+			continue
+		} else if g.Deny {
+			denied = true
+		} else {
+			granted = true
+		}
+	}
+
+	return granted, denied
+}
+
+// Effective returns rs plus all the roles they inherit from,
+// directly or indirectly, in the role hierarchy.
+func (a *ACL) Effective(rs ...string) []string {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.hierarchy.Effective(rs...)
+}
+
+// Rebuilds a.patterns from a.acl.
+// Must be called with a.mu locked.
+func (a *ACL) index() {
+
+	a.patterns = []Grant{}
+	for g := range a.acl {
+		if g.IsPattern() || g.Deny {
+			a.patterns = append(a.patterns, g)
+		}
+	}
+}
+
 // Defines an interface for ACL data access.
 type IAclDataSource interface {
 
 	// Returns all grants mapped to its corresponding validity time range.
 	Fetch() (Acl, error)
+
+	// Saves a new grant valid within r.
+	Insert(g Grant, r TimeRange) error
+
+	// Sets the grant's validity end to at.
+	Expire(g Grant, at time.Time) error
+
+	// Removes the grant.
+	Delete(g Grant) error
 }
 
-// Meant to be executed on startup, Init loads the acl map in memory.
+// Returns a copy of the default in-memory acl.
+func Entries() Acl {
+
+	return acl.Entries()
+}
+
+// Entries returns a copy of the in-memory acl.
+func (a *ACL) Entries() Acl {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	m := make(Acl, len(a.acl))
+	for g, r := range a.acl {
+		m[g] = r
+	}
+	return m
+}
+
+// Put adds or replaces g in the default in-memory acl.
+func Put(g Grant, r TimeRange) {
+
+	acl.Put(g, r)
+}
+
+// Put adds or replaces g in the in-memory acl.
+// Meant to keep it in sync after IAclDataSource.Insert.
+func (a *ACL) Put(g Grant, r TimeRange) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.acl == nil {
+		a.acl = make(Acl)
+	}
+	a.acl[g] = r
+	a.index()
+}
+
+// Expire sets g's validity end to at in the default in-memory acl.
+func Expire(g Grant, at time.Time) {
+
+	acl.Expire(g, at)
+}
+
+// Expire sets g's validity end to at in the in-memory acl.
+// Meant to keep it in sync after IAclDataSource.Expire.
+func (a *ACL) Expire(g Grant, at time.Time) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r, ok := a.acl[g]; ok {
+		r.To = at
+		a.acl[g] = r
+	}
+	a.index()
+}
+
+// Remove deletes g from the default in-memory acl.
+func Remove(g Grant) {
+
+	acl.Remove(g)
+}
+
+// Remove deletes g from the in-memory acl.
+// Meant to keep it in sync after IAclDataSource.Delete.
+func (a *ACL) Remove(g Grant) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.acl, g)
+	a.index()
+}
+
+// Meant to be executed on startup, Init loads the default acl map in memory.
+func Init(fn AclDSFactory, d IDataSource) (err error) {
+
+	return acl.Init(fn, d)
+}
+
+// Init loads the acl map in memory.
 // acl maps each grant to a time range.
 // Helps speed up Authorization middleware.
-func Init(fn AclDSFactory, d IDataSource) (err error) {
+// The data source is kept to allow for later Reload calls.
+func (a *ACL) Init(fn AclDSFactory, d IDataSource) (err error) {
 
 	if dsrc, err := fn(d); err != nil {
 		return err
-	} else if acl, err = dsrc.Fetch(); err != nil {
+	} else if m, err := dsrc.Fetch(); err != nil {
 		return err
+	} else {
+		a.mu.Lock()
+		a.acl, a.aclLoader = m, dsrc.Fetch
+		a.index()
+		a.mu.Unlock()
 	}
 
 	return nil
 }
+
+// Reload reloads the default acl and role hierarchy maps.
+func Reload() (diff AclDiff, err error) {
+
+	return acl.Reload()
+}
+
+// Reload fetches the acl and role hierarchy maps again from
+// the data sources set on Init and InitRoles, and swaps them
+// with the in-memory ones. Concurrent Authorization middleware
+// calls see either the old or the new maps, never a mix.
+// The returned diff is logged as well.
+func (a *ACL) Reload() (diff AclDiff, err error) {
+
+	a.reloading.Lock()
+	defer a.reloading.Unlock()
+
+	a.mu.RLock()
+	m, h, fa, fr := a.acl, a.hierarchy, a.aclLoader, a.roleLoader
+	a.mu.RUnlock()
+
+	if fa != nil {
+		if m, err = fa(); err != nil {
+			return diff, err
+		}
+	}
+
+	if fr != nil {
+		if h, err = fr(); err != nil {
+			return diff, err
+		}
+	}
+
+	a.mu.Lock()
+	diff = a.acl.Diff(m)
+	a.acl, a.hierarchy = m, h
+	a.index()
+	a.mu.Unlock()
+
+	glog.Infof("ACL reloaded: %d added, %d removed, %d changed", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	for _, g := range diff.Added {
+		glog.Infof("ACL grant added: %s %s %s deny=%t", g.Role, g.Method, g.Route, g.Deny)
+	}
+	for _, g := range diff.Removed {
+		glog.Infof("ACL grant removed: %s %s %s deny=%t", g.Role, g.Method, g.Route, g.Deny)
+	}
+	for _, g := range diff.Changed {
+		glog.Infof("ACL grant changed: %s %s %s deny=%t", g.Role, g.Method, g.Route, g.Deny)
+	}
+	glog.Flush()
+
+	return diff, nil
+}
+
+// Watch reloads the default acl every d, until ctx is done.
+func Watch(ctx context.Context, d time.Duration) {
+
+	acl.Watch(ctx, d)
+}
+
+// Watch calls Reload every d, until ctx is done.
+// Reload errors are logged and the in-memory maps are kept.
+func (a *ACL) Watch(ctx context.Context, d time.Duration) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
+		}
+		if _, err := a.Reload(); err != nil {
+			glog.Error(err)
+			glog.Flush()
+		}
+	}
+}
+
+// Diff returns the grants added, removed or
+// with a changed time range in m compared to a.
+func (a Acl) Diff(m Acl) (diff AclDiff) {
+
+	diff = AclDiff{Added: []Grant{}, Removed: []Grant{}, Changed: []Grant{}}
+
+	for g, r := range m {
+		if o, ok := a[g]; !ok {
+			diff.Added = append(diff.Added, g)
+		} else if !o.From.Equal(r.From) || !o.To.Equal(r.To) {
+			diff.Changed = append(diff.Changed, g)
+		}
+	}
+
+	for g := range a {
+		if _, ok := m[g]; !ok {
+			diff.Removed = append(diff.Removed, g)
+		}
+	}
+
+	return diff
+}
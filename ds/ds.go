@@ -31,5 +31,14 @@ type UserDSFactory func(dsrc IDataSource) (IUserDataSource, error)
 // AclDSFactory makes a IAclDataSource from a generic dsrc IDataSource.
 type AclDSFactory func(dsrc IDataSource) (IAclDataSource, error)
 
+// RoleDSFactory makes a IRoleDataSource from a generic dsrc IDataSource.
+type RoleDSFactory func(dsrc IDataSource) (IRoleDataSource, error)
+
+// KeyDSFactory makes a IKeyDataSource from a generic dsrc IDataSource.
+type KeyDSFactory func(dsrc IDataSource) (IKeyDataSource, error)
+
+// SecretDSFactory makes a ISecretDataSource from a generic dsrc IDataSource.
+type SecretDSFactory func(dsrc IDataSource) (ISecretDataSource, error)
+
 // AclDSFactory makes a IPinDataSource from generic p(pin) and u(user) IDataSource's.
 type PinDSFactory func(p, u IDataSource) (IPinDataSource, error)
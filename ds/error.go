@@ -75,3 +75,18 @@ type InsertError struct {
 type UpdateError struct {
 	msg.Message
 }
+
+// ReusedPassword exported
+type ReusedPassword struct {
+	msg.Message
+}
+
+// PinAttemptsError exported
+type PinAttemptsError struct {
+	msg.Message
+}
+
+// ReplayedOTP exported
+type ReplayedOTP struct {
+	msg.Message
+}
@@ -0,0 +1,19 @@
+package ds
+
+// Defines an interface to retrieve users by API key.
+type IKeyDataSource interface {
+
+	// Return the active User owning the API key.
+	// Keys are stored hashed with lib.HashKey, and looked
+	// up by each of lib.HashKeys.
+	Get(hash string) (User, error)
+}
+
+// Defines an interface to retrieve users and their
+// shared secrets for HMAC request signing.
+type ISecretDataSource interface {
+
+	// Return the active User identified by the key id
+	// along with the shared secret used to sign requests.
+	Get(id string) (User, string, error)
+}
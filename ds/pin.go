@@ -5,7 +5,9 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/jwt"
 	"github.com/zicare/rgm/lib"
 	"github.com/zicare/rgm/mail"
 )
@@ -19,6 +21,33 @@ type IPinDataSource interface {
 
 	// Patch password
 	PatchPwd(patch *Patch, crypto lib.ICrypto) error
+
+	// Verify the pin posted for email, i.e. to unlock a locked out user
+	Verify(email, pin string) error
+
+	// Post Pin for a pending account, to confirm its email
+	Issue(email string) (Pin, error)
+
+	// Activate the pending account matching email, provided a valid pin
+	Activate(email, pin string) error
+}
+
+// IPinPurpose is implemented by IPinDataSource's keeping apart the
+// pins issued for different purposes, for issuing one, i.e. a sign-in
+// link, not to invalidate another, i.e. a password reset pin.
+type IPinPurpose interface {
+
+	// Return the data source issuing and verifying pins for purpose only.
+	// Pins issued through the data source itself have an empty purpose.
+	For(purpose string) (IPinDataSource, error)
+}
+
+// IPinRevoker is implemented by IPinDataSource's revoking the JWTs
+// of the users whose password is patched, check IUserRevoker.
+type IPinRevoker interface {
+
+	// Return the data source revoking JWTs in r.
+	Revoking(r *jwt.Registry) IPinDataSource
 }
 
 type Pin struct {
@@ -26,16 +55,31 @@ type Pin struct {
 	Code       string    `json:"code"`
 	Created    time.Time `json:"created"`
 	Expiration time.Time `json:"expiration"`
+	Attempts   int       `json:"attempts"`
 }
 
-func (p Pin) Send() {
+// Send sends the pin by email with m, i.e. mail.Of(c).
+func (p Pin) Send(m *mail.Mailer) error {
 
 	msg := new(mail.Message)
-	msg.To = p.Email
+	msg.To = []string{p.Email}
 	msg.Subject = "Has recibido un PIN"
 	msg.Tpl = "pin.tpl"
 	msg.Data = struct{ PIN string }{PIN: p.Code}
-	msg.Send(1)
+	msg.Sensitive = true
+	return m.Send(msg)
+}
+
+// SendLink sends url, a sign-in link carrying the pin, by email with m.
+func (p Pin) SendLink(m *mail.Mailer, url string) error {
+
+	msg := new(mail.Message)
+	msg.To = []string{p.Email}
+	msg.Subject = "Tu enlace de acceso"
+	msg.Tpl = "magic.tpl"
+	msg.Data = struct{ URL string }{URL: url}
+	msg.Sensitive = true
+	return m.Send(msg)
 }
 
 type Patch struct {
@@ -46,6 +90,14 @@ type Patch struct {
 
 func PatchReceiver() interface{} {
 
+	return PatchReceiverWith(config.Config())
+}
+
+// PatchReceiverWith returns a struct pointer to bind a password
+// patch with, validating passwords with the account.pwd_validation
+// setting in cf.
+func PatchReceiverWith(cf *viper.Viper) interface{} {
+
 	return reflect.New(reflect.StructOf([]reflect.StructField{
 		{
 			Name: "Pin",
@@ -60,7 +112,7 @@ func PatchReceiver() interface{} {
 		{
 			Name: "Password",
 			Type: reflect.TypeOf(string("")),
-			Tag:  reflect.StructTag(`json:"pwd" binding:"` + config.Config().GetString("account.pwd_validation") + `"`),
+			Tag:  reflect.StructTag(`json:"pwd" binding:"` + cf.GetString("account.pwd_validation") + `"`),
 		},
 	})).Elem().Addr().Interface()
 }
@@ -81,3 +133,44 @@ func PatchDecoder(pr any) *Patch {
 
 	return patch
 }
+
+// PwdChange as received to change the password of
+// an authenticated user.
+type PwdChange struct {
+	Current  string `json:"pwd_current"`
+	Password string `json:"pwd"`
+}
+
+func PwdChangeReceiver() interface{} {
+
+	return PwdChangeReceiverWith(config.Config())
+}
+
+// PwdChangeReceiverWith returns a struct pointer to bind a password
+// change with, validating passwords with the account.pwd_validation
+// setting in cf.
+func PwdChangeReceiverWith(cf *viper.Viper) interface{} {
+
+	return reflect.New(reflect.StructOf([]reflect.StructField{
+		{
+			Name: "Current",
+			Type: reflect.TypeOf(string("")),
+			Tag:  `json:"pwd_current" binding:"required"`,
+		},
+		{
+			Name: "Password",
+			Type: reflect.TypeOf(string("")),
+			Tag:  reflect.StructTag(`json:"pwd" binding:"` + cf.GetString("account.pwd_validation") + `"`),
+		},
+	})).Elem().Addr().Interface()
+}
+
+func PwdChangeDecoder(pr any) *PwdChange {
+
+	change := new(PwdChange)
+
+	data, _ := json.Marshal(pr)
+	json.Unmarshal(data, change)
+
+	return change
+}
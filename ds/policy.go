@@ -0,0 +1,171 @@
+package ds
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Effect exported
+type Effect int
+
+const (
+	// Permit grants access if the condition holds,
+	// even with no acl grant for the user's roles.
+	Permit Effect = iota
+	// Deny revokes access if the condition holds,
+	// overriding any acl grant or Permit policy.
+	Deny
+)
+
+// String exported
+func (e Effect) String() string {
+
+	if e == Deny {
+		return "deny"
+	}
+	return "permit"
+}
+
+// Attributes a Policy condition is evaluated against.
+type Attributes struct {
+
+	// Authenticated user attributes.
+	User User
+
+	// Request attributes.
+	Route  string
+	Method string
+	IP     string
+	Time   time.Time
+	Header http.Header
+	Params map[string]string
+
+	// Resource attributes, as loaded by Policy.Resource.
+	// nil if the policy sets no loader.
+	Resource interface{}
+}
+
+// Policy exported
+type Policy struct {
+
+	// Identifies the policy in decisions.
+	Name string
+
+	// Whether the policy permits or denies access.
+	Effect Effect
+
+	// The route and method the policy applies to.
+	// Empty values match any route or method.
+	// Route can be a pattern, check Match, and Method can be "*".
+	Route  string
+	Method string
+
+	// Optional, loads the resource attributes for the condition,
+	// i.e. the record addressed by the route params.
+	Resource func(c *gin.Context) (interface{}, error)
+
+	// Go predicate over the attributes.
+	Condition func(a Attributes) bool
+}
+
+// Decision exported
+type Decision struct {
+	Allowed bool     `json:"allowed"`
+	Reasons []string `json:"reasons"`
+}
+
+// AddPolicy registers p in the default ACL.
+func AddPolicy(p Policy) {
+
+	acl.AddPolicy(p)
+}
+
+// AddPolicy registers p to be evaluated by Authorization middleware.
+func (a *ACL) AddPolicy(p Policy) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.policies = append(a.policies, p)
+}
+
+// Returns true if p applies to route and method.
+func (p Policy) applies(route, method string) bool {
+
+	return (p.Route == "" || Match(p.Route, route)) &&
+		(p.Method == "" || p.Method == "*" || p.Method == method)
+}
+
+// Evaluate decides if u can access the requested route,
+// against the ACL of the request, check ACLOf.
+func Evaluate(c *gin.Context, u User) (d Decision) {
+
+	return ACLOf(c).Evaluate(c, u)
+}
+
+// Evaluate decides if u can access the requested route.
+// Access is allowed if any of u's roles, or the roles they
+// inherit from, has a valid acl grant, or if any applicable
+// Permit policy holds. Any valid acl deny grant, or applicable
+// Deny policy that holds, overrides both.
+// Every step is explained in Decision.Reasons.
+func (acl *ACL) Evaluate(c *gin.Context, u User) (d Decision) {
+
+	var (
+		route       = c.FullPath()
+		method      = c.Request.Method
+		grant, deny = acl.lookup(u.Roles, route, method)
+		permit      = false
+	)
+
+	d.Reasons = []string{fmt.Sprintf("acl grant for roles %v on %s %s: %t", u.Roles, method, route, grant)}
+	if deny {
+		d.Reasons = append(d.Reasons, fmt.Sprintf("acl deny grant for roles %v on %s %s", u.Roles, method, route))
+	}
+
+	acl.mu.RLock()
+	ps := acl.policies
+	acl.mu.RUnlock()
+
+	a := Attributes{
+		User:   u,
+		Route:  route,
+		Method: method,
+		IP:     c.ClientIP(),
+		Time:   time.Now(),
+		Header: c.Request.Header,
+		Params: make(map[string]string),
+	}
+	for _, p := range c.Params {
+		a.Params[p.Key] = p.Value
+	}
+
+	for _, p := range ps {
+		if !p.applies(route, method) || p.Condition == nil {
+			continue
+		}
+		a.Resource = nil
+		if p.Resource != nil {
+			r, err := p.Resource(c)
+			if err != nil {
+				deny = true
+				d.Reasons = append(d.Reasons, fmt.Sprintf("policy %s: resource not loaded, %s", p.Name, err.Error()))
+				continue
+			}
+			a.Resource = r
+		}
+		holds := p.Condition(a)
+		d.Reasons = append(d.Reasons, fmt.Sprintf("policy %s (%s): %t", p.Name, p.Effect, holds))
+		if holds && p.Effect == Deny {
+			deny = true
+		} else if holds && p.Effect == Permit {
+			permit = true
+		}
+	}
+
+	d.Allowed = (grant || permit) && !deny
+	return d
+}
@@ -0,0 +1,108 @@
+package ds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+
+	gin.SetMode(gin.TestMode)
+}
+
+// Returns the decision of a for u on GET /items/:id.
+func evaluate(a *ACL, u User) Decision {
+
+	var d Decision
+
+	r := gin.New()
+	r.GET("/items/:id", func(c *gin.Context) {
+		d = a.Evaluate(c, u)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/1", nil))
+
+	return d
+}
+
+func TestEvaluateDenyOverrides(t *testing.T) {
+
+	var (
+		always = TimeRange{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)}
+		grant  = Grant{Role: "user", Route: "/items/:id", Method: http.MethodGet}
+		deny   = Grant{Role: "user", Route: "/items/*", Method: "*", Deny: true}
+		permit = Policy{Name: "permit", Effect: Permit, Condition: func(Attributes) bool { return true }}
+		denyP  = Policy{Name: "deny", Effect: Deny, Condition: func(Attributes) bool { return true }}
+		other  = Policy{Name: "other", Effect: Permit, Route: "/other", Condition: func(Attributes) bool { return true }}
+		u      = User{Roles: []string{"user"}}
+	)
+
+	for _, tc := range []struct {
+		name     string
+		grants   []Grant
+		policies []Policy
+		allowed  bool
+	}{
+		{"no grant", nil, nil, false},
+		{"grant", []Grant{grant}, nil, true},
+		{"pattern grant", []Grant{{Role: "user", Route: "/items/*", Method: "*"}}, nil, true},
+		{"permit policy", nil, []Policy{permit}, true},
+		{"policy for another route", nil, []Policy{other}, false},
+		{"deny grant over grant", []Grant{grant, deny}, nil, false},
+		{"deny grant over permit policy", []Grant{deny}, []Policy{permit}, false},
+		{"deny policy over grant", []Grant{grant}, []Policy{denyP}, false},
+		{"deny policy over permit policy", nil, []Policy{permit, denyP}, false},
+	} {
+		a := NewACL()
+		for _, g := range tc.grants {
+			a.Put(g, always)
+		}
+		for _, p := range tc.policies {
+			a.AddPolicy(p)
+		}
+
+		if d := evaluate(a, u); d.Allowed != tc.allowed {
+			t.Errorf("%s: allowed %t, want %t, reasons %v", tc.name, d.Allowed, tc.allowed, d.Reasons)
+		}
+	}
+}
+
+func TestEvaluateAttributes(t *testing.T) {
+
+	var (
+		acl   = NewACL()
+		attrs Attributes
+	)
+
+	acl.AddPolicy(Policy{Name: "owner", Effect: Permit, Condition: func(a Attributes) bool {
+		attrs = a
+		return a.Params["id"] == a.User.UID
+	}})
+
+	if d := evaluate(acl, User{UID: "1"}); !d.Allowed {
+		t.Errorf("owner denied, reasons %v", d.Reasons)
+	} else if attrs.Route != "/items/:id" || attrs.Method != http.MethodGet {
+		t.Errorf("unexpected attributes %+v", attrs)
+	}
+	if d := evaluate(acl, User{UID: "2"}); d.Allowed {
+		t.Errorf("non owner allowed, reasons %v", d.Reasons)
+	}
+}
+
+func TestEvaluateExpiredDenyGrant(t *testing.T) {
+
+	var (
+		now = time.Now()
+		a   = NewACL()
+	)
+
+	a.Put(Grant{Role: "user", Route: "/items/:id", Method: http.MethodGet}, TimeRange{From: now.Add(-time.Hour), To: now.Add(time.Hour)})
+	a.Put(Grant{Role: "user", Route: "/items/*", Method: "*", Deny: true}, TimeRange{From: now.Add(-time.Hour), To: now.Add(-time.Minute)})
+
+	if d := evaluate(a, User{Roles: []string{"user"}}); !d.Allowed {
+		t.Errorf("expired deny grant applied, reasons %v", d.Reasons)
+	}
+}
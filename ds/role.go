@@ -0,0 +1,62 @@
+package ds
+
+// Hierarchy maps each role to the parent roles it inherits grants from.
+// i.e. admin ⊇ manager ⊇ clerk is loaded as
+// Hierarchy{"admin": {"manager"}, "manager": {"clerk"}}
+type Hierarchy map[string][]string
+
+// Defines an interface for role hierarchy data access.
+type IRoleDataSource interface {
+
+	// Returns all roles mapped to the parent roles they inherit from.
+	Fetch() (Hierarchy, error)
+}
+
+// Returns rs plus all the roles they inherit from, directly or indirectly.
+// Cycles in the hierarchy are tolerated, each role is returned once.
+func (h Hierarchy) Effective(rs ...string) []string {
+
+	var (
+		effective = []string{}
+		seen      = make(map[string]bool)
+		queue     = append([]string{}, rs...)
+	)
+
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		effective = append(effective, role)
+		queue = append(queue, h[role]...)
+	}
+
+	return effective
+}
+
+// Meant to be executed on startup, InitRoles loads
+// the default ACL's hierarchy map in memory.
+func InitRoles(fn RoleDSFactory, d IDataSource) (err error) {
+
+	return acl.InitRoles(fn, d)
+}
+
+// InitRoles loads the hierarchy map in memory.
+// hierarchy maps each role to the parent roles it inherits grants from.
+// The data source is kept to allow for later Reload calls.
+func (a *ACL) InitRoles(fn RoleDSFactory, d IDataSource) (err error) {
+
+	if dsrc, err := fn(d); err != nil {
+		return err
+	} else if h, err := dsrc.Fetch(); err != nil {
+		return err
+	} else {
+		a.mu.Lock()
+		a.hierarchy, a.roleLoader = h, dsrc.Fetch
+		a.mu.Unlock()
+	}
+
+	return nil
+}
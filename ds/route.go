@@ -0,0 +1,51 @@
+package ds
+
+import (
+	"strings"
+)
+
+// Match reports whether route matches pattern.
+// Both are split in "/" delimited segments and compared one by one.
+// In pattern, a ":name" or "*" segment matches any single segment,
+// and a trailing "*" segment matches one or more remaining segments.
+//
+// Example:
+//
+// Match("/admin/*", "/admin/users/:id") -> true
+// Match("/orders/:id/*", "/orders/:order_id/items") -> true
+// Match("/orders/:id", "/orders/:id/items") -> false
+//
+func Match(pattern, route string) bool {
+
+	var (
+		ps = strings.Split(strings.Trim(pattern, "/"), "/")
+		rs = strings.Split(strings.Trim(route, "/"), "/")
+	)
+
+	for i, p := range ps {
+		if p == "*" && i == len(ps)-1 {
+			return len(rs) > i && rs[i] != ""
+		} else if i >= len(rs) {
+			return false
+		} else if p == "*" || strings.HasPrefix(p, ":") {
+			continue
+		} else if p != rs[i] {
+			return false
+		}
+	}
+
+	return len(ps) == len(rs)
+}
+
+// IsPattern reports whether g's route or method hold wildcards.
+func (g Grant) IsPattern() bool {
+
+	return g.Method == "*" || strings.Contains(g.Route, "*") || strings.Contains(g.Route, ":")
+}
+
+// Matches reports whether g applies to route and method.
+// g.Method "*" matches any method.
+func (g Grant) Matches(route, method string) bool {
+
+	return (g.Method == "*" || g.Method == method) && Match(g.Route, route)
+}
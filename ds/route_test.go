@@ -0,0 +1,23 @@
+package ds
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+
+	for _, tc := range []struct {
+		pattern, route string
+		match          bool
+	}{
+		{"/admin/*", "/admin/users/:id", true},
+		{"/admin/*", "/admin", false},
+		{"/orders/:id/*", "/orders/:order_id/items", true},
+		{"/orders/:id", "/orders/:id/items", false},
+		{"/orders/*/items", "/orders/:id/items", true},
+		{"/orders", "/orders", true},
+		{"/orders", "/users", false},
+	} {
+		if m := Match(tc.pattern, tc.route); m != tc.match {
+			t.Errorf("Match(%q, %q) = %t, want %t", tc.pattern, tc.route, m, tc.match)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package ds
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/msg"
+)
+
+// Pending reports whether u signed up and is yet to confirm
+// its email. Pending accounts have an empty From/To range.
+func (u User) Pending() bool {
+
+	return u.From.Equal(u.To)
+}
+
+// SignupReceiver returns a struct pointer to bind and validate
+// signup credentials with. Passwords are validated with
+// account.pwd_validation.
+func SignupReceiver() interface{} {
+
+	return SignupReceiverWith(config.Config())
+}
+
+// SignupReceiverWith returns a struct pointer to bind signup
+// credentials with, validating passwords with the
+// account.pwd_validation setting in cf.
+func SignupReceiverWith(cf *viper.Viper) interface{} {
+
+	return reflect.New(reflect.StructOf([]reflect.StructField{
+		{
+			Name: "Email",
+			Type: reflect.TypeOf(string("")),
+			Tag:  `json:"usr" binding:"required,email"`,
+		},
+		{
+			Name: "Password",
+			Type: reflect.TypeOf(string("")),
+			Tag:  reflect.StructTag(`json:"pwd" binding:"` + cf.GetString("account.pwd_validation") + `"`),
+		},
+	})).Elem().Addr().Interface()
+}
+
+// Pend prepares user, a user IDataSource bound from a signup request,
+// to be inserted as a pending account. Columns the requester can't
+// choose, i.e. scopes, totp or recovery, are cleared first, that is
+// every db tagged field but usr and the profile fields listed, by
+// their json tag, in account.signup_fields. Then it sets the hashed password and an empty From/To range, and
+// role and tps from the account.signup_role and account.signup_tps
+// settings.
+func Pend(user IDataSource, pwd string, crypto lib.ICrypto) error {
+
+	return PendWith(config.Config(), user, pwd, crypto)
+}
+
+// PendWith prepares user to be inserted as a pending account,
+// as Pend does, with the account.* settings in cf.
+func PendWith(cf *viper.Viper, user IDataSource, pwd string, crypto lib.ICrypto) error {
+
+	clearTagged(user, append([]string{"usr"}, cf.GetStringSlice("account.signup_fields")...))
+
+	now := time.Now()
+	set := map[string]interface{}{
+		"pwd":  crypto.Encode(pwd),
+		"from": now,
+		"to":   now,
+		"role": cf.Get("account.signup_role"),
+		"tps":  cf.Get("account.signup_tps"),
+	}
+
+	for tag, v := range set {
+		if err := setTagged(user, "json", tag, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets to their zero value the db tagged fields of dsrc,
+// but the ones whose json tag is in keep.
+func clearTagged(dsrc IDataSource, keep []string) {
+
+	r := reflect.Indirect(reflect.ValueOf(dsrc))
+	for i := 0; i < r.NumField(); i++ {
+
+		sf := r.Type().Field(i)
+		if db, ok := sf.Tag.Lookup("db"); !ok || db == "-" {
+			continue
+		} else if tag, ok := sf.Tag.Lookup("json"); ok && lib.Contains(keep, strings.Split(tag, ",")[0]) {
+			continue
+		} else if f := r.Field(i); f.CanSet() {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+}
+
+// Sets the field of dsrc tagged tagKey:"tagValue" to v,
+// converting it to the field type. A nil v sets the zero value.
+func setTagged(dsrc IDataSource, tagKey string, tagValue string, v interface{}) error {
+
+	r := reflect.Indirect(reflect.ValueOf(dsrc))
+	for i := 0; i < r.NumField(); i++ {
+
+		if tag, ok := r.Type().Field(i).Tag.Lookup(tagKey); !ok || strings.Split(tag, ",")[0] != tagValue {
+			continue
+		}
+
+		f := r.Field(i)
+		if v == nil {
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+
+		t := f.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		// numbers convert to strings as runes, rule it out
+		val := reflect.ValueOf(v)
+		if !val.Type().ConvertibleTo(t) || (t.Kind() == reflect.String && val.Kind() != reflect.String) {
+			break
+		}
+		val = val.Convert(t)
+
+		if f.Kind() == reflect.Ptr {
+			p := reflect.New(t)
+			p.Elem().Set(val)
+			val = p
+		}
+		f.Set(val)
+		return nil
+	}
+
+	err := new(TagError)
+	err.Copy(msg.Get("2").SetArgs("User"))
+	return err
+}
@@ -113,6 +113,37 @@ func TagValuesPivoted(dsrc IDataSource, targetTagKey string, pivotTagKey string,
 	return targetTagValues, nil
 }
 
+// Works like TagValuesPivoted but for optional fields.
+//
+// Returns a map of the pivot tag values found to their
+// corresponding target tag values. Pivot tag values not
+// matched are simply left out of the map.
+//
+// Example:
+//
+// fields := TagValuesOptional(new(User), "db", "json", []string{"scopes"})
+// fields -> map[string]string{"scopes": "scopes"} if a scopes field is tagged.
+//
+func TagValuesOptional(dsrc IDataSource, targetTagKey string, pivotTagKey string, pivotTagValues []string) map[string]string {
+
+	targetTagValues := make(map[string]string)
+
+	t := reflect.ValueOf(dsrc).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if ptv, ok := t.Type().Field(i).Tag.Lookup(pivotTagKey); ok {
+			if ttv, ok := t.Type().Field(i).Tag.Lookup(targetTagKey); ok && ttv != "-" {
+				for _, v := range pivotTagValues {
+					if v == ptv {
+						targetTagValues[v] = ttv
+					}
+				}
+			}
+		}
+	}
+
+	return targetTagValues
+}
+
 /*
 func TaggedFields(tbl IDataSource, tagName string, tagValues []string) ([]string, *TagError) {
 
@@ -1,9 +1,14 @@
 package ds
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/jwt"
+	"github.com/zicare/rgm/lib"
 )
 
 // User exported
@@ -16,6 +21,30 @@ type User struct {
 	TPS  float32   `json:"tps"`
 	From time.Time `json:"from"`
 	To   time.Time `json:"to"`
+
+	// All the roles held by the user, Role being the first one.
+	Roles []string `json:"roles,omitempty"`
+
+	// OAuth2 like scopes the user is entitled to.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Base32 encoded TOTP secret, empty if
+	// two-factor authentication is disabled.
+	TOTP string `json:"-"`
+
+	// Hashed single use recovery codes, accepted
+	// in place of a TOTP code.
+	Recovery []string `json:"-"`
+
+	// Last time the password was changed, zero if unknown.
+	Changed time.Time `json:"-"`
+
+	// Hashes of previous passwords, most recent first.
+	History []string `json:"-"`
+
+	// Application defined custom claims.
+	// Set from the JWT "ext" payload member on JWT authentication.
+	Claims json.RawMessage `json:"claims,omitempty"`
 }
 
 // Defines an interface to retrieve user data
@@ -25,8 +54,53 @@ type IUserDataSource interface {
 	// Return the active User matching the username
 	Get(username string) (User, error)
 
-	// Patch password for matching username
-	//PatchPwd(patch Patch) error
+	// Set the TOTP secret and hashed recovery codes for matching username.
+	// An empty secret disables two-factor authentication.
+	PatchTOTP(username string, secret string, recovery []string) error
+
+	// Record step as the last TOTP time step a code was accepted at for
+	// matching username, provided it's after the recorded one, for codes
+	// not to be replayed. Returns *ReplayedOTP otherwise.
+	PatchTOTPStep(username string, step int64) error
+
+	// Change the password of the user matching uid, provided its current password.
+	// Passwords in the user's history can't be reused, check account.pwd_history.
+	ChangePwd(uid string, current string, pwd string, crypto lib.ICrypto) error
+
+	// Replace the password hash of the user matching uid with encoded,
+	// the same password hashed again, i.e. with a stronger algorithm.
+	RehashPwd(uid string, encoded string) error
+}
+
+// IUserRevoker is implemented by IUserDataSource's revoking the JWTs
+// of the users whose password changes, for the registry to revoke
+// them in to be set, i.e. the App's one, instead of the default one.
+type IUserRevoker interface {
+
+	// Return the data source revoking JWTs in r.
+	Revoking(r *jwt.Registry) IUserDataSource
+}
+
+// Active reports whether the current time is within u's validity range.
+func (u User) Active() bool {
+
+	now := time.Now()
+	return !now.Before(u.From) && !now.After(u.To)
+}
+
+// PwdExpired reports whether u's password is older than
+// account.pwd_max_age. Passwords never expire if not set.
+func (u User) PwdExpired() bool {
+
+	return u.PwdExpiredWith(config.Config())
+}
+
+// PwdExpiredWith reports whether u's password is older
+// than the account.pwd_max_age setting in cf.
+func (u User) PwdExpiredWith(cf *viper.Viper) bool {
+
+	max := cf.GetDuration("account.pwd_max_age")
+	return max > 0 && !u.Changed.IsZero() && time.Since(u.Changed) > max
 }
 
 // Returns the authenticated UID or empty
@@ -41,6 +115,18 @@ func UID(c *gin.Context) string {
 	return ""
 }
 
+// Returns the authenticated user's roles or nil
+// if authentication was skipped.
+func Roles(c *gin.Context) []string {
+
+	if u, exists := c.Get("User"); !exists {
+		return nil
+	} else if u, ok := u.(User); ok {
+		return u.Roles
+	}
+	return nil
+}
+
 // Returns the authenticated Role or empty
 // string if authentication was skipped.
 func Role(c *gin.Context) string {
@@ -52,3 +138,17 @@ func Role(c *gin.Context) string {
 	}
 	return ""
 }
+
+// Decodes the authenticated user's custom claims into v.
+// v is left untouched if authentication was skipped
+// or no custom claims were set.
+func Claims(c *gin.Context, v interface{}) error {
+
+	if u, exists := c.Get("User"); !exists {
+		return nil
+	} else if u, ok := u.(User); !ok || len(u.Claims) == 0 {
+		return nil
+	} else {
+		return json.Unmarshal(u.Claims, v)
+	}
+}
@@ -23,3 +23,18 @@ type TamperedToken struct {
 type ExpiredToken struct {
 	msg.Message
 }
+
+// NotYetValidToken exported
+type NotYetValidToken struct {
+	msg.Message
+}
+
+// InvalidIssuer exported
+type InvalidIssuer struct {
+	msg.Message
+}
+
+// InvalidAudience exported
+type InvalidAudience struct {
+	msg.Message
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/lib"
 )
@@ -13,6 +14,9 @@ type JWT struct {
 	header  Header
 	payload Payload
 	token   string
+
+	// Signed with cf's hmac_key, check JWTFactoryWith.
+	cf *viper.Viper
 }
 
 type Header struct {
@@ -21,20 +25,34 @@ type Header struct {
 }
 
 type Payload struct {
-	UID  string    `json:"uid"`
-	Type string    `json:"type"`
-	Role string    `json:"role"`
-	TPS  float32   `json:"tps"`
-	Iat  time.Time `json:"iat"`
-	Exp  time.Time `json:"exp"`
+	UID    string          `json:"uid"`
+	Type   string          `json:"type"`
+	Role   string          `json:"role"`
+	Roles  []string        `json:"roles,omitempty"`
+	TPS    float32         `json:"tps"`
+	Scopes []string        `json:"scopes,omitempty"`
+	Iss    string          `json:"iss,omitempty"`
+	Aud    []string        `json:"aud,omitempty"`
+	Iat    time.Time       `json:"iat"`
+	Nbf    time.Time       `json:"nbf"`
+	Exp    time.Time       `json:"exp"`
+	Ext    json.RawMessage `json:"ext,omitempty"`
 }
 
 // Returns token and exp for an auth.User
 func JWTFactory(uid string, role string, t string, tps float32, iat time.Time, exp time.Time) JWT {
 
+	return JWTFactoryWith(config.Config(), uid, role, t, tps, iat, exp)
+}
+
+// JWTFactoryWith returns token and exp for an auth.User, as JWTFactory
+// does, with the jwt_* settings and hmac_key in cf, i.e. an App's ones.
+func JWTFactoryWith(cf *viper.Viper, uid string, role string, t string, tps float32, iat time.Time, exp time.Time) JWT {
+
 	var (
 		now         = time.Now()
-		duration, _ = time.ParseDuration(config.Config().GetString("jwt_duration"))
+		duration, _ = time.ParseDuration(cf.GetString("jwt_duration"))
+		aud         []string
 	)
 
 	// Cap exp
@@ -47,16 +65,58 @@ func JWTFactory(uid string, role string, t string, tps float32, iat time.Time, e
 		iat = now
 	}
 
-	return jWT(Payload{
+	if a := cf.GetString("jwt_audience"); a != "" {
+		aud = []string{a}
+	}
+
+	return jWT(cf, Payload{
 		UID:  uid,
 		Type: t,
 		Role: role,
 		TPS:  tps,
+		Iss:  cf.GetString("jwt_issuer"),
+		Aud:  aud,
 		Iat:  iat,
+		Nbf:  iat,
 		Exp:  exp,
 	})
 }
 
+// SetClaims returns a copy of j carrying claims
+// as custom claims under the "ext" payload member.
+// claims can be any json serializable value,
+// use Payload.Claims to decode them back.
+// j is returned untouched along the error if claims
+// can't be serialized.
+func (j JWT) SetClaims(claims interface{}) (JWT, error) {
+
+	if claims == nil {
+		j.payload.Ext = nil
+	} else if ext, err := json.Marshal(claims); err != nil {
+		return j, err
+	} else {
+		j.payload.Ext = ext
+	}
+	return jWT(j.cf, j.payload), nil
+}
+
+// SetRoles returns a copy of j carrying all the roles held by the user.
+// role, as passed to JWTFactory, is expected to be the first one.
+func (j JWT) SetRoles(roles ...string) JWT {
+
+	j.payload.Roles = roles
+	return jWT(j.cf, j.payload)
+}
+
+// SetScopes returns a copy of j carrying scopes.
+// Scopes are required by mw.Authorization on routes
+// registered with scopes.
+func (j JWT) SetScopes(scopes ...string) JWT {
+
+	j.payload.Scopes = scopes
+	return jWT(j.cf, j.payload)
+}
+
 func (j JWT) ToString() string {
 
 	return j.token
@@ -72,9 +132,28 @@ func (j JWT) GetPayload() Payload {
 	return j.payload
 }
 
+// Claims decodes the custom claims into v.
+// v should be a pointer to the application's claims type.
+func (p Payload) Claims(v interface{}) error {
+
+	if len(p.Ext) == 0 {
+		return nil
+	} else if err := json.Unmarshal(p.Ext, v); err != nil {
+		return new(InvalidTokenPayload)
+	}
+	return nil
+}
+
 // Decode exported
 func Decode(token string) (Payload, error) {
 
+	return DecodeWith(config.Config(), token)
+}
+
+// DecodeWith decodes and validates token, as Decode does,
+// with the jwt_* settings and hmac_key in cf.
+func DecodeWith(cf *viper.Viper, token string) (Payload, error) {
+
 	var payload Payload
 
 	t := strings.Split(token, ".")
@@ -92,24 +171,45 @@ func Decode(token string) (Payload, error) {
 		return payload, new(InvalidTokenPayload)
 	}
 
-	j := jWT(payload)
+	j := jWT(cf, payload)
 
 	if token != j.token {
 		return payload, new(TamperedToken)
 	}
 
-	if time.Now().After(j.payload.Exp) {
-		return payload, new(ExpiredToken)
-	}
+	return payload, validate(cf, payload)
 
-	return payload, nil
+}
+
+// Validates the registered claims exp, nbf, iss and aud.
+// Time based claims are checked allowing for the
+// clock skew set as jwt_leeway in the configuration file.
+func validate(cf *viper.Viper, payload Payload) error {
+
+	var (
+		now       = time.Now()
+		leeway, _ = time.ParseDuration(cf.GetString("jwt_leeway"))
+		iss       = cf.GetString("jwt_issuer")
+		aud       = cf.GetString("jwt_audience")
+	)
+
+	if now.Add(-1 * leeway).After(payload.Exp) {
+		return new(ExpiredToken)
+	} else if now.Add(leeway).Before(payload.Nbf) {
+		return new(NotYetValidToken)
+	} else if iss != "" && payload.Iss != iss {
+		return new(InvalidIssuer)
+	} else if aud != "" && !lib.Contains(payload.Aud, aud) {
+		return new(InvalidAudience)
+	}
 
+	return nil
 }
 
-func jWT(payload Payload) JWT {
+func jWT(cf *viper.Viper, payload Payload) JWT {
 
 	var (
-		secret    = config.Config().GetString("hmac_key")
+		secret    = cf.GetString("hmac_key")
 		header    = Header{Typ: "JWT", Alg: "HS256"}
 		src       = lib.B64Encode(header) + "." + lib.B64Encode(payload)
 		signature = lib.Hash(src, secret)
@@ -120,5 +220,6 @@ func jWT(payload Payload) JWT {
 		header:  header,
 		payload: payload,
 		token:   token,
+		cf:      cf,
 	}
 }
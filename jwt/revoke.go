@@ -1,64 +1,156 @@
 package jwt
 
 import (
+	"context"
+	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/zicare/rgm/config"
 )
 
-// Keeps a registry of users with a JWT revoke alert.
-var revokedJWTMap map[string]map[string]time.Time
-
-// Initializes and cleans up the revokedJWTMap registry.
-// revokeJWTMap negates the use of fresh JWTs issued before access revoke.
-// revokedJWTMap keys represent the users and the values a revoke alert timestamp.
+// Registry keeps a registry of users with a JWT revoke alert.
+// It negates the use of fresh JWTs issued before access revoke.
+// Its keys represent the users and the values a revoke alert timestamp.
 // Any JWT issued before said timestamp will be reported as revoked by IsRevoked.
 // Entries' lifetime is equal to the JWT lifetime,
 // this garantees that all JWT issued before the revoke alert
 // will be reported as revoked. Obsolete entries are deleted
-// automatically by the go-routine.
+// by CleanUp.
+// Registries are safe for concurrent use.
+type Registry struct {
+
+	// Entries' lifetime, the jwt_duration setting if zero.
+	TTL time.Duration
+
+	// Guards m, written by RevokeJWT
+	// i.e. on password changes, while read by IsRevoked.
+	mu sync.RWMutex
+	m  map[string]map[string]time.Time
+}
+
+// The default registry, check Default.
+var revokedJWTMap = NewRegistry()
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+
+	return &Registry{m: map[string]map[string]time.Time{}}
+}
+
+// Default returns the Registry the package level functions work on.
+func Default() *Registry {
+
+	return revokedJWTMap
+}
+
+// SetDefault sets r as the Registry the package level functions work on.
+func SetDefault(r *Registry) {
+
+	revokedJWTMap = r
+}
+
+// Of returns the Registry set in the "Revoked" context key,
+// i.e. by rgm.App, or the default one.
+func Of(c *gin.Context) *Registry {
+
+	if v, exists := c.Get("Revoked"); !exists {
+		return revokedJWTMap
+	} else if v, ok := v.(*Registry); ok {
+		return v
+	}
+	return revokedJWTMap
+}
+
+// Initializes the default registry, check Registry.
 // It is the responsability of the client app to add the entries.
 // JWT lifetime is set in the configuration files.
 func Init() {
 
-	revokedJWTMap = map[string]map[string]time.Time{}
-
-	go func() {
-		mcl := time.Duration(60) * time.Second
-		jwtDuration, _ := time.ParseDuration(config.Config().GetString("jwt_duration"))
-		for {
-			for k1, v1 := range revokedJWTMap {
-				for k2, v2 := range v1 {
-					if v2.Before(time.Now().Add(-1 * jwtDuration)) {
-						delete(revokedJWTMap[k1], k2)
-					}
+	RevokedJWTReset()
+}
+
+// CleanUp deletes obsolete entries from the default registry
+// every minute, until ctx is done.
+func CleanUp(ctx context.Context) {
+
+	revokedJWTMap.CleanUp(ctx)
+}
+
+// CleanUp deletes obsolete entries every minute, until ctx is done.
+func (r *Registry) CleanUp(ctx context.Context) {
+
+	mcl := time.Duration(60) * time.Second
+	jwtDuration := r.TTL
+	if jwtDuration == 0 {
+		jwtDuration, _ = time.ParseDuration(config.Config().GetString("jwt_duration"))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(mcl):
+		}
+		r.mu.Lock()
+		for k1, v1 := range r.m {
+			for k2, v2 := range v1 {
+				if v2.Before(time.Now().Add(-1 * jwtDuration)) {
+					delete(r.m[k1], k2)
 				}
 			}
-			time.Sleep(mcl)
 		}
-	}()
+		r.mu.Unlock()
+	}
 }
 
 //RevokeJWT exported
 func RevokeJWT(t string, uid string) {
 
-	if _, ok := revokedJWTMap[t]; !ok {
-		revokedJWTMap[t] = map[string]time.Time{}
+	revokedJWTMap.Revoke(t, uid)
+}
+
+// Revoke adds a revoke alert for the user of type t matching uid.
+func (r *Registry) Revoke(t string, uid string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.m[t]; !ok {
+		r.m[t] = map[string]time.Time{}
 	}
 
-	revokedJWTMap[t][uid] = time.Now()
+	r.m[t][uid] = time.Now()
 }
 
 // RevokedJWTReset exported
 func RevokedJWTReset() {
 
-	revokedJWTMap = map[string]map[string]time.Time{}
+	revokedJWTMap.Reset()
+}
+
+// Reset removes all the entries.
+func (r *Registry) Reset() {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.m = map[string]map[string]time.Time{}
 }
 
 // IsRevoked exported
 func IsRevoked(payload Payload) bool {
 
-	ts, revoked := revokedJWTMap[payload.Type][payload.UID]
+	return revokedJWTMap.IsRevoked(payload)
+}
+
+// IsRevoked reports whether payload was issued
+// before its user's revoke alert.
+func (r *Registry) IsRevoked(payload Payload) bool {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ts, revoked := r.m[payload.Type][payload.UID]
 
 	if revoked {
 		return ts.After(payload.Iat)
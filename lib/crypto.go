@@ -2,12 +2,19 @@ package lib
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
 )
 
 type ICrypto interface {
@@ -19,22 +26,120 @@ type ICrypto interface {
 	Compare(plain, encoded string) bool
 }
 
-type crypto struct{}
+// IRehasher can be implemented by ICrypto's to tell hashes that should
+// be encoded again, i.e. by BasicAuthentication while the password is
+// at hand. Crypto implements it.
+type IRehasher interface {
 
-func (crypto) Encode(plain string) string {
+	// Validates if encoded was made with an outdated
+	// algorithm, cost or pepper, and should be encoded again
+	NeedsRehash(encoded string) bool
+}
+
+// Hashes are encoded as $rgm1$<pepper id>$<hash>, hash being in
+// modular crypt format, i.e. $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>.
+// Unversioned bcrypt hashes, as encoded before, are still supported,
+// and taken as peppered with pepper id "0".
+//
+// The algorithm, argon2id, scrypt or bcrypt, and its cost are set in the
+// crypto.* configuration settings. The pepper is the pepper setting,
+// identified by pepper_id ("0" if not set). When rotating it, former
+// peppers must be kept in the peppers setting, mapped by their id,
+// for existing hashes to be verified.
+const version = "$rgm1$"
+
+type crypto struct {
 
-	pepper := config.Config().GetString("pepper")
-	encoded, _ := bcrypt.GenerateFromPassword([]byte([]byte(plain+pepper)), bcrypt.DefaultCost)
-	return string(encoded)
+	// Settings are read from cf, the default configuration if nil.
+	cf *viper.Viper
 }
 
-func (crypto) Compare(plain, encoded string) bool {
+func (c crypto) Encode(plain string) string {
+
+	var (
+		id, pepper = c.currentPepper()
+		pwd        = []byte(plain + pepper)
+		hash       string
+	)
+
+	switch c.algorithm() {
+	case "argon2id":
+		t, m, p, l := c.argon2Params()
+		salt := salt()
+		key := argon2.IDKey(pwd, salt, t, m, p, l)
+		hash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, m, t, p, b64(salt), b64(key))
+	case "scrypt":
+		n, r, p, l := c.scryptParams()
+		salt := salt()
+		key, _ := scrypt.Key(pwd, salt, n, r, p, l)
+		hash = fmt.Sprintf("$scrypt$n=%d,r=%d,p=%d$%s$%s", n, r, p, b64(salt), b64(key))
+	default:
+		encoded, _ := bcrypt.GenerateFromPassword(pwd, c.bcryptCost())
+		hash = string(encoded)
+	}
 
-	pepper := config.Config().GetString("pepper")
-	if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plain+pepper)); err != nil {
+	return version + id + hash
+}
+
+func (c crypto) Compare(plain, encoded string) bool {
+
+	id, hash := split(encoded)
+
+	pepper, ok := c.pepper(id)
+	if !ok {
 		return false
 	}
-	return true
+	pwd := []byte(plain + pepper)
+
+	switch f := strings.Split(hash, "$"); {
+	case len(f) == 6 && f[1] == "argon2id":
+		var m, t uint32
+		var p uint8
+		if _, err := fmt.Sscanf(f[3], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+			return false
+		} else if salt, key, ok := unb64(f[4], f[5]); !ok {
+			return false
+		} else {
+			return subtle.ConstantTimeCompare(key, argon2.IDKey(pwd, salt, t, m, p, uint32(len(key)))) == 1
+		}
+	case len(f) == 5 && f[1] == "scrypt":
+		var n, r, p int
+		if _, err := fmt.Sscanf(f[2], "n=%d,r=%d,p=%d", &n, &r, &p); err != nil {
+			return false
+		} else if salt, key, ok := unb64(f[3], f[4]); !ok {
+			return false
+		} else if k, err := scrypt.Key(pwd, salt, n, r, p, len(key)); err != nil {
+			return false
+		} else {
+			return subtle.ConstantTimeCompare(key, k) == 1
+		}
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hash), pwd) == nil
+	}
+}
+
+func (c crypto) NeedsRehash(encoded string) bool {
+
+	if !strings.HasPrefix(encoded, version) {
+		return true
+	}
+
+	id, hash := split(encoded)
+	if current, _ := c.currentPepper(); id != current {
+		return true
+	}
+
+	switch c.algorithm() {
+	case "argon2id":
+		t, m, p, _ := c.argon2Params()
+		return !strings.HasPrefix(hash, fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$", argon2.Version, m, t, p))
+	case "scrypt":
+		n, r, p, _ := c.scryptParams()
+		return !strings.HasPrefix(hash, fmt.Sprintf("$scrypt$n=%d,r=%d,p=%d$", n, r, p))
+	default:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != c.bcryptCost()
+	}
 }
 
 func Crypto() crypto {
@@ -42,9 +147,172 @@ func Crypto() crypto {
 	return crypto{}
 }
 
+// NewCrypto returns an ICrypto, implementing IRehasher as well,
+// reading the crypto.* and pepper settings from cf.
+func NewCrypto(cf *viper.Viper) crypto {
+
+	return crypto{cf: cf}
+}
+
+// Returns the configuration c reads its settings from.
+func (c crypto) conf() *viper.Viper {
+
+	if c.cf != nil {
+		return c.cf
+	}
+	return config.Config()
+}
+
+// Returns the pepper id and hash of encoded.
+func split(encoded string) (id, hash string) {
+
+	if !strings.HasPrefix(encoded, version) {
+		return "0", encoded
+	}
+
+	s := strings.TrimPrefix(encoded, version)
+	if i := strings.Index(s, "$"); i >= 0 {
+		return s[:i], s[i:]
+	}
+	return "", s
+}
+
+func (c crypto) currentPepper() (id, pepper string) {
+
+	if id = c.conf().GetString("pepper_id"); id == "" {
+		id = "0"
+	}
+	return id, c.conf().GetString("pepper")
+}
+
+func (c crypto) pepper(id string) (string, bool) {
+
+	if current, pepper := c.currentPepper(); id == current {
+		return pepper, true
+	}
+	peppers := c.conf().GetStringMapString("peppers")
+	pepper, ok := peppers[id]
+	return pepper, ok
+}
+
+func (c crypto) algorithm() string {
+
+	return c.conf().GetString("crypto.algorithm")
+}
+
+func (c crypto) bcryptCost() int {
+
+	if v := c.conf().GetInt("crypto.bcrypt.cost"); v >= bcrypt.MinCost && v <= bcrypt.MaxCost {
+		return v
+	}
+	return bcrypt.DefaultCost
+}
+
+// Returns argon2id time, memory (KiB), threads and key length.
+func (c crypto) argon2Params() (t, m uint32, p uint8, l uint32) {
+
+	t, m, p, l = 1, 64*1024, 4, 32
+	if v := c.conf().GetUint32("crypto.argon2id.time"); v > 0 {
+		t = v
+	}
+	if v := c.conf().GetUint32("crypto.argon2id.memory"); v > 0 {
+		m = v
+	}
+	if v := c.conf().GetUint("crypto.argon2id.threads"); v > 0 && v < 256 {
+		p = uint8(v)
+	}
+	return t, m, p, l
+}
+
+// Returns scrypt N, r, p and key length.
+func (c crypto) scryptParams() (n, r, p, l int) {
+
+	n, r, p, l = 32768, 8, 1, 32
+	if v := c.conf().GetInt("crypto.scrypt.n"); v > 1 && v&(v-1) == 0 {
+		n = v
+	}
+	if v := c.conf().GetInt("crypto.scrypt.r"); v > 0 {
+		r = v
+	}
+	if v := c.conf().GetInt("crypto.scrypt.p"); v > 0 {
+		p = v
+	}
+	return n, r, p, l
+}
+
+func salt() []byte {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b
+}
+
+func b64(b []byte) string {
+
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func unb64(s, k string) (salt, key []byte, ok bool) {
+
+	var err error
+	if salt, err = base64.RawStdEncoding.DecodeString(s); err != nil {
+		return nil, nil, false
+	} else if key, err = base64.RawStdEncoding.DecodeString(k); err != nil {
+		return nil, nil, false
+	}
+	return salt, key, true
+}
+
 func Hash(src string, secret string) string {
 	key := []byte(secret)
 	h := hmac.New(sha256.New, key)
 	h.Write([]byte(src))
 	return strings.TrimRight(base64.StdEncoding.EncodeToString(h.Sum(nil)), "=")
 }
+
+// HashKey returns the peppered hash API keys, and pins, are stored with,
+// peppered with the default configuration's pepper.
+func HashKey(key string) string {
+
+	return Crypto().HashKey(key)
+}
+
+// HashKey returns the peppered hash API keys, and pins, are stored with.
+func (c crypto) HashKey(key string) string {
+
+	_, pepper := c.currentPepper()
+	return Hash(key, pepper)
+}
+
+// HashKeys returns the hashes key may have been stored with,
+// peppered with the default configuration's peppers.
+func HashKeys(key string) []string {
+
+	return Crypto().HashKeys(key)
+}
+
+// HashKeys returns the hashes key may have been stored with, peppered
+// with the current pepper, first, and with each of the former ones in
+// the peppers setting, for keys stored before a pepper rotation to be
+// looked up.
+func (c crypto) HashKeys(key string) []string {
+
+	var (
+		id, pepper = c.currentPepper()
+		peppers    = c.conf().GetStringMapString("peppers")
+		ids        = make([]string, 0, len(peppers))
+		hashes     = []string{Hash(key, pepper)}
+	)
+
+	for k := range peppers {
+		if k != id {
+			ids = append(ids, k)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, k := range ids {
+		hashes = append(hashes, Hash(key, peppers[k]))
+	}
+	return hashes
+}
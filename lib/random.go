@@ -1,38 +1,38 @@
 package lib
 
 import (
-	"math/rand"
-	"time"
-	"unsafe"
+	"crypto/rand"
+	"math/big"
 )
 
-//RandString returns a random string of fixed length
+//RandString returns a cryptographically secure random string of fixed length
 func RandString(n int) string {
 
-	const (
-		letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789" // 52 possibilities
-		letterIdxBits = 6                                                                // 6 bits to represent 64 possibilities / indexes
-		letterIdxMask = 1<<letterIdxBits - 1                                             // All 1-bits, as many as letterIdxBits
-		letterIdxMax  = 63
-	)
+	return randFrom("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", n)
+}
+
+// RandCode returns a cryptographically secure random string of fixed
+// length, made of uppercase letters and digits, i.e. for pins.
+func RandCode(n int) string {
+
+	return randFrom("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", n)
+}
+
+// Returns n characters picked uniformly from letters.
+func randFrom(letters string, n int) string {
 
 	var (
-		src = rand.NewSource(time.Now().UnixNano())
 		b   = make([]byte, n)
+		max = big.NewInt(int64(len(letters)))
 	)
 
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
-		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
 		}
-		cache >>= letterIdxBits
-		remain--
+		b[i] = letters[idx.Int64()]
 	}
 
-	return *(*string)(unsafe.Pointer(&b))
+	return string(b)
 }
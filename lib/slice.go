@@ -20,3 +20,14 @@ func Contains(s []string, e string) bool {
 	}
 	return false
 }
+
+//Intersect exported
+func Intersect(a []string, b []string) []string {
+	var c []string
+	for _, e := range a {
+		if Contains(b, e) {
+			c = append(c, e)
+		}
+	}
+	return c
+}
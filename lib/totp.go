@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+)
+
+// TOTP (RFC 6238) settings are read from the totp.* configuration
+// settings: issuer, digits (6 to 8), period and skew, the number of
+// periods before and after the current one also accepted.
+// The With variants read them from the given configuration, i.e.
+// an App's one, instead of the default one.
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPSecret returns a new random base32 encoded TOTP secret.
+func TOTPSecret() (string, error) {
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// TOTPURI returns the provisioning URI authenticator apps
+// enrol with, usually scanned as a QR code.
+func TOTPURI(secret, account string) string {
+
+	return TOTPURIWith(config.Config(), secret, account)
+}
+
+// TOTPURIWith returns the provisioning URI for secret and
+// account, as TOTPURI does, with the totp.* settings in cf.
+func TOTPURIWith(cf *viper.Viper, secret, account string) string {
+
+	issuer := cf.GetString("totp.issuer")
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits(cf)))
+	v.Set("period", fmt.Sprint(int(totpPeriod(cf).Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPCode returns the TOTP code for secret at t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+
+	return TOTPCodeWith(config.Config(), secret, t)
+}
+
+// TOTPCodeWith returns the TOTP code for secret at t,
+// as TOTPCode does, with the totp.* settings in cf.
+func TOTPCodeWith(cf *viper.Viper, secret string, t time.Time) (string, error) {
+
+	return totpCode(cf, secret, uint64(t.Unix()/int64(totpPeriod(cf).Seconds())))
+}
+
+// TOTPVerify validates code for secret at the current
+// period, or within totp.skew periods before or after.
+func TOTPVerify(secret, code string) bool {
+
+	return TOTPVerifyWith(config.Config(), secret, code)
+}
+
+// TOTPVerifyWith validates code for secret, as
+// TOTPVerify does, with the totp.* settings in cf.
+func TOTPVerifyWith(cf *viper.Viper, secret, code string) bool {
+
+	_, ok := TOTPStepWith(cf, secret, code)
+	return ok
+}
+
+// TOTPStep validates code as TOTPVerify does, and returns
+// the time step it matched, i.e. to reject it once used.
+func TOTPStep(secret, code string) (int64, bool) {
+
+	return TOTPStepWith(config.Config(), secret, code)
+}
+
+// TOTPStepWith validates code and returns the time step it
+// matched, as TOTPStep does, with the totp.* settings in cf.
+func TOTPStepWith(cf *viper.Viper, secret, code string) (int64, bool) {
+
+	var (
+		step = time.Now().Unix() / int64(totpPeriod(cf).Seconds())
+		skew = int64(cf.GetInt("totp.skew"))
+	)
+
+	for i := -skew; i <= skew; i++ {
+		if c, err := totpCode(cf, secret, uint64(step+i)); err == nil && subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
+// RecoveryCodes returns n random single use recovery codes.
+func RecoveryCodes(n int) ([]string, error) {
+
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		codes[i] = strings.ToLower(b32.EncodeToString(b))
+	}
+	return codes, nil
+}
+
+// IsRecoveryCode reports whether s is shaped as the
+// codes returned by RecoveryCodes, so that it's worth
+// comparing against the hashed ones.
+func IsRecoveryCode(s string) bool {
+
+	if len(s) != 8 {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '2' || r > '7') {
+			return false
+		}
+	}
+	return true
+}
+
+// HOTP (RFC 4226) for counter.
+func totpCode(cf *viper.Viper, secret string, counter uint64) (string, error) {
+
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	digits := totpDigits(cf)
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, bin%mod), nil
+}
+
+func totpDigits(cf *viper.Viper) int {
+
+	if d := cf.GetInt("totp.digits"); d >= 6 && d <= 8 {
+		return d
+	}
+	return 6
+}
+
+func totpPeriod(cf *viper.Viper) time.Duration {
+
+	if p := cf.GetDuration("totp.period"); p >= time.Second {
+		return p
+	}
+	return 30 * time.Second
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+)
+
+// RFC 6238 SHA1 test secret, "12345678901234567890" base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Sets a configuration with settings as the default one for t.
+func setConfig(t *testing.T, settings map[string]interface{}) {
+
+	prev := config.Config()
+	t.Cleanup(func() { config.Set(prev) })
+
+	cf := viper.New()
+	for k, v := range settings {
+		cf.Set(k, v)
+	}
+	config.Set(cf)
+}
+
+func TestTOTPCode(t *testing.T) {
+
+	setConfig(t, map[string]interface{}{"totp.digits": 8})
+
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		if code, err := TOTPCode(rfcSecret, time.Unix(tc.unix, 0)); err != nil {
+			t.Fatal(err)
+		} else if code != tc.code {
+			t.Errorf("at %d got %s, want %s", tc.unix, code, tc.code)
+		}
+	}
+}
+
+func TestTOTPStep(t *testing.T) {
+
+	setConfig(t, map[string]interface{}{"totp.skew": 1})
+
+	secret, err := TOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		now  = time.Now()
+		step = now.Unix() / 30
+		code = func(d time.Duration) string {
+			c, err := TOTPCode(secret, now.Add(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c
+		}
+	)
+
+	if s, ok := TOTPStep(secret, code(0)); !ok || s != step {
+		t.Errorf("current code: step %d %t, want %d", s, ok, step)
+	}
+	if s, ok := TOTPStep(secret, code(-30*time.Second)); !ok || s != step-1 {
+		t.Errorf("previous code within skew: step %d %t, want %d", s, ok, step-1)
+	}
+	if _, ok := TOTPStep(secret, code(-90*time.Second)); ok {
+		t.Error("code beyond skew accepted")
+	}
+	if TOTPVerify(secret, "abcdef") {
+		t.Error("malformed code accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+
+	codes, err := RecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range codes {
+		if !IsRecoveryCode(c) {
+			t.Errorf("%q not taken as a recovery code", c)
+		}
+	}
+
+	for _, s := range []string{"", "123456", "abcdefg1", "ABCDEFGH", "abcdefghi"} {
+		if IsRecoveryCode(s) {
+			t.Errorf("%q taken as a recovery code", s)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package lockout
+
+import (
+	"github.com/zicare/rgm/msg"
+)
+
+// ThresholdRange exported
+type ThresholdRange struct {
+	msg.Message
+}
+
+// DelayRange exported
+type DelayRange struct {
+	msg.Message
+}
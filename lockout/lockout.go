@@ -0,0 +1,271 @@
+// Lockout package.
+// This package allows for brute-force protection of authentication.
+// Failed attempts are counted per key, i.e. a username or an IP.
+// Once a key reaches the configured threshold of failed attempts,
+// it is locked out for a delay that doubles on every new failure,
+// up to a maximum delay. A successful authentication resets the key.
+// Failure counters of keys not failing for a whole window
+// and not locked out are forgotten.
+//
+// The package level functions work on the default Control, set by Init.
+// Use New for independent ones. A nil Control is a disabled one, it
+// never locks a key out.
+package lockout
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/msg"
+)
+
+type entry struct {
+
+	// Number of consecutive failed attempts
+	fails int
+
+	// Last failed attempt timestamp
+	ts time.Time
+
+	// The lockout. Attempts won't be allowed before this time.
+	until time.Time
+}
+
+// Control is a lockout control instance, its registry of failed
+// attempts along its settings. Controls are safe for concurrent use.
+type Control struct {
+
+	// Failed attempts allowed before locking out
+	threshold int
+
+	// Initial lockout delay, max lockout delay and
+	// failure counters' lifetime
+	delay, maxDelay, window time.Duration
+
+	// Messages are looked up in msgs, the default catalog if nil.
+	msgs msg.Catalog
+
+	// The registry, guarded by mu.
+	entries map[string]*entry
+	purged  time.Time
+	mu      sync.Mutex
+}
+
+// The default Control set by Init, nil if lockout control is disabled.
+var control *Control
+
+// Init function initializes the default lockout control
+// with the lockout.* configuration settings, check New.
+func Init() error {
+
+	c, err := New(config.Config(), nil)
+	if err != nil {
+		return err
+	}
+
+	control = c
+	return nil
+}
+
+// New returns a lockout control with the lockout.* settings in cf,
+// looking its messages up in m, i.e. an App's catalog, or in the
+// default one if nil.
+func New(cf *viper.Viper, m msg.Catalog) (*Control, error) {
+
+	c := &Control{
+		threshold: cf.GetInt("lockout.threshold"),
+		delay:     cf.GetDuration("lockout.delay"),
+		maxDelay:  cf.GetDuration("lockout.max_delay"),
+		window:    cf.GetDuration("lockout.window"),
+		msgs:      m,
+		entries:   map[string]*entry{},
+	}
+
+	if (c.threshold < 1) || (c.threshold > 100) {
+		return nil, &ThresholdRange{c.message("56").SetArgs("1", "100")}
+	} else if (c.delay <= 0) || (c.maxDelay < c.delay) || (c.window <= 0) {
+		return nil, &DelayRange{c.message("57")}
+	} else {
+		return c, nil
+	}
+}
+
+// Returns the message matching key in c's catalog.
+func (c *Control) message(key string) msg.Message {
+
+	if c.msgs != nil {
+		return c.msgs.Get(key)
+	}
+	return msg.Get(key)
+}
+
+// Default returns the Control the package level
+// functions work on, nil if lockout control is disabled.
+func Default() *Control {
+
+	return control
+}
+
+// SetDefault sets c as the Control the package level functions
+// work on. A nil c disables lockout control.
+func SetDefault(c *Control) {
+
+	control = c
+}
+
+// Of returns the Control set in the "Lockout" context key,
+// i.e. by rgm.App, or the default one.
+func Of(c *gin.Context) *Control {
+
+	if v, exists := c.Get("Lockout"); !exists {
+		return control
+	} else if v, ok := v.(*Control); ok {
+		return v
+	}
+	return control
+}
+
+// IsEnabled exported.
+// Just by calling Init() once, IsEnabled will return true.
+func IsEnabled() bool {
+
+	return control != nil
+}
+
+// UsrKey returns the key failed attempts are accounted by for a username.
+func UsrKey(username string) string {
+
+	return "usr:" + username
+}
+
+// IPKey returns the key failed attempts are accounted by for an IP.
+func IPKey(ip string) string {
+
+	return "ip:" + ip
+}
+
+// Locked checks keys in the default Control.
+func Locked(keys ...string) *time.Time {
+
+	return control.Locked(keys...)
+}
+
+// Locked returns the latest lockout expiry among keys,
+// or nil if none of them is currently locked out.
+func (c *Control) Locked(keys ...string) *time.Time {
+
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.locked(time.Now(), keys...)
+}
+
+func (c *Control) locked(now time.Time, keys ...string) *time.Time {
+
+	var until *time.Time
+	for _, k := range keys {
+		if e, ok := c.entries[k]; ok && e.until.After(now) && (until == nil || e.until.After(*until)) {
+			u := e.until
+			until = &u
+		}
+	}
+	return until
+}
+
+// Fail takes note of a failed attempt for keys in the default Control.
+func Fail(keys ...string) *time.Time {
+
+	return control.Fail(keys...)
+}
+
+// Fail takes note of a failed attempt for each key,
+// and returns the latest lockout expiry among keys, if any.
+// Keys becoming locked out are logged.
+func (c *Control) Fail(keys ...string) *time.Time {
+
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.purge(now)
+
+	for _, k := range keys {
+		e, ok := c.entries[k]
+		if !ok {
+			e = new(entry)
+			c.entries[k] = e
+		}
+		e.fails++
+		e.ts = now
+		if e.fails >= c.threshold {
+			d := c.delay << uint(shift(e.fails-c.threshold))
+			if d <= 0 || d > c.maxDelay {
+				d = c.maxDelay
+			}
+			e.until = now.Add(d)
+			glog.Warning(c.message("55").SetArgs(k, e.fails, e.until.Format(time.RFC3339)).String())
+		}
+	}
+	glog.Flush()
+
+	return c.locked(now, keys...)
+}
+
+// Reset forgets the failed attempts of keys in the default Control.
+func Reset(keys ...string) {
+
+	control.Reset(keys...)
+}
+
+// Reset forgets the failed attempts and lockouts of keys.
+func (c *Control) Reset(keys ...string) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		delete(c.entries, k)
+	}
+}
+
+// Caps the delay shift, larger shifts would overflow anyway.
+func shift(n int) int {
+
+	if n > 32 {
+		return 32
+	}
+	return n
+}
+
+// Removes obsolete entries, not failing for a whole window
+// and not locked out. The registry is inspected once every window.
+// Must be called with c.mu locked.
+func (c *Control) purge(now time.Time) {
+
+	if c.purged.After(now.Add(-c.window)) {
+		return
+	}
+
+	for k, e := range c.entries {
+		if e.ts.Before(now.Add(-c.window)) && !e.until.After(now) {
+			delete(c.entries, k)
+		}
+	}
+	c.purged = now
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"sync"
+)
+
+// Capture keeps the emails sent through it in memory instead of
+// delivering them, for tests to assert on them with no mail server.
+// i.e. set it with Mailer.SetTransport, call ds.Pin.Send, Drain the
+// outbox, and check Sent.
+type Capture struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+// NewCapture returns an empty Capture.
+func NewCapture() *Capture {
+
+	return new(Capture)
+}
+
+// Send exported
+func (c *Capture) Send(e Email) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent = append(c.sent, e)
+	return nil
+}
+
+// Sent returns the emails sent so far, the oldest first.
+func (c *Capture) Sent() []Email {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Email{}, c.sent...)
+}
+
+// Reset forgets the emails sent so far.
+func (c *Capture) Reset() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent = nil
+}
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"github.com/zicare/rgm/msg"
+)
+
+// NotFoundError exported
+type NotFoundError struct {
+	msg.Message
+}
+
+// NotDeadError exported
+type NotDeadError struct {
+	msg.Message
+}
+
+// UnknownTransport exported
+type UnknownTransport struct {
+	msg.Message
+}
+
+// TemplateError exported
+type TemplateError struct {
+	msg.Message
+}
+
+// NoRecipients exported
+type NoRecipients struct {
+	msg.Message
+}
+
+// RedactedError exported
+type RedactedError struct {
+	msg.Message
+}
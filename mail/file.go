@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zicare/rgm/lib"
+)
+
+// File writes each email as an .eml file in Dir,
+// i.e. to inspect them during development.
+type File struct {
+	Dir string
+}
+
+// Send exported
+func (f File) Send(e Email) error {
+
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), lib.RandString(8))
+	return write(filepath.Join(f.Dir, name), e)
+}
+
+// Maildir delivers each email to the Maildir in Dir, creating it if
+// needed. Emails are written to tmp and then moved to new, for mail
+// clients never to see a partial one.
+type Maildir struct {
+	Dir string
+}
+
+// Send exported
+func (md Maildir) Send(e Email) error {
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(md.Dir, sub), 0o700); err != nil {
+			return err
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+
+	var (
+		name = fmt.Sprintf("%d.%d_%s.%s", time.Now().Unix(), os.Getpid(), lib.RandString(12), host)
+		tmp  = filepath.Join(md.Dir, "tmp", name)
+	)
+
+	if err := write(tmp, e); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
+
+// Writes e as a MIME message to a new file at path.
+func write(path string, e Email) error {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := compose(e).WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,235 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Fails every email sent through it.
+type failing struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (f *failing) Send(e Email) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.n++
+	return errors.New("connection refused")
+}
+
+// Returns a Mailer with an in-memory outbox and settings.
+func newMailer(settings map[string]interface{}) *Mailer {
+
+	cf := viper.New()
+	cf.Set("hmac_key", "test-key")
+	cf.Set("smtp.from", "noreply@example.com")
+	for k, v := range settings {
+		cf.Set(k, v)
+	}
+	return New(cf, nil)
+}
+
+// Makes the entry matching id due right away.
+func due(t *testing.T, m *Mailer, id string) {
+
+	e, err := m.outbox.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Next = time.Now()
+	if err := m.outbox.Save(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	m := newMailer(map[string]interface{}{
+		"smtp.retry_interval": "1m",
+		"outbox.max_backoff":  "5m",
+	})
+
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	} {
+		if d := m.backoff(tc.attempts); d != tc.want {
+			t.Errorf("after %d attempts wait %v, want %v", tc.attempts, d, tc.want)
+		}
+	}
+}
+
+func TestRetryUntilDead(t *testing.T) {
+
+	var (
+		m = newMailer(map[string]interface{}{"smtp.retries": 2, "smtp.retry_interval": "1m"})
+		f = new(failing)
+		c = NewCapture()
+	)
+	m.SetTransport(f)
+
+	if err := m.outbox.Add(Entry{ID: "1", Email: Email{To: []string{"a@example.com"}, Subject: "hi", HTML: "<p>hi</p>"}, Status: Pending, Next: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// first attempt fails, retried after the back-off
+	start := time.Now()
+	if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := m.outbox.Get("1")
+	if e.Status != Pending || e.Attempts != 1 || e.Error == "" {
+		t.Fatalf("after a failure got %s, %d attempts, error %q", e.Status, e.Attempts, e.Error)
+	} else if e.Next.Before(start.Add(time.Minute)) {
+		t.Fatalf("retried at %v, before the back-off", e.Next)
+	} else if f.n != 1 {
+		t.Fatalf("%d attempts made, want 1", f.n)
+	}
+
+	// not due yet
+	if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if f.n != 1 {
+		t.Fatalf("entry sent before due, %d attempts made", f.n)
+	}
+
+	// second attempt fails, the last one
+	due(t, m, "1")
+	if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ = m.outbox.Get("1"); e.Status != Dead || e.Attempts != 2 {
+		t.Fatalf("after the last failure got %s, %d attempts", e.Status, e.Attempts)
+	} else if e.Email.Redacted || e.Email.HTML == "" {
+		t.Fatal("dead entry redacted, it can't be resent")
+	}
+
+	// resent once the server is back
+	m.SetTransport(c)
+	if err := m.Resend("1"); err != nil {
+		t.Fatal(err)
+	} else if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ = m.outbox.Get("1"); e.Status != Sent || e.Attempts != 1 {
+		t.Fatalf("after resending got %s, %d attempts", e.Status, e.Attempts)
+	} else if len(c.Sent()) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(c.Sent()))
+	}
+
+	if err := m.Resend("1"); err == nil {
+		t.Fatal("sent entry resent")
+	}
+}
+
+func TestSendCapture(t *testing.T) {
+
+	// templates are read relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "tpl", "email"), 0o755); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(filepath.Join(dir, "tpl", "email", "pin.tpl"), []byte(`<html><body><p>Your PIN is <b>{{.PIN}}</b></p></body></html>`), 0o644); err != nil {
+		t.Fatal(err)
+	} else if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var (
+		m = newMailer(nil)
+		c = NewCapture()
+	)
+	m.SetTransport(c)
+
+	msg := &Message{
+		To:        []string{"a@example.com"},
+		Subject:   "Your PIN",
+		Tpl:       "pin.tpl",
+		Data:      struct{ PIN string }{PIN: "K7Q2ZX"},
+		Sensitive: true,
+	}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pin is kept encrypted in the outbox
+	l, err := m.List(Pending)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(l) != 1 {
+		t.Fatalf("%d pending entries, want 1", len(l))
+	} else if raw, _ := json.Marshal(l[0]); strings.Contains(string(raw), "K7Q2ZX") {
+		t.Fatalf("outbox entry carries the pin in plain text: %s", raw)
+	}
+
+	if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := c.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(sent))
+	}
+	e := sent[0]
+	if e.From != "noreply@example.com" || e.Subject != "Your PIN" || len(e.To) != 1 || e.To[0] != "a@example.com" {
+		t.Errorf("unexpected headers %+v", e)
+	}
+	if !strings.Contains(e.HTML, "<b>K7Q2ZX</b>") {
+		t.Errorf("html body %q lacks the pin", e.HTML)
+	}
+	if e.Text != "Your PIN is K7Q2ZX\n" {
+		t.Errorf("text body %q", e.Text)
+	}
+
+	// and redacted once sent
+	if l, _ = m.List(Sent); len(l) != 1 || !l[0].Email.Redacted || l[0].Email.Sealed != "" || l[0].Email.HTML != "" {
+		t.Fatalf("sent entry not redacted: %+v", l)
+	}
+}
+
+func TestSendNoRecipients(t *testing.T) {
+
+	if err := newMailer(nil).Send(&Message{Subject: "hi", Tpl: "pin.tpl"}); err == nil {
+		t.Fatal("expected an error")
+	} else if _, ok := err.(*NoRecipients); !ok {
+		t.Fatalf("got %T, want *NoRecipients", err)
+	}
+}
+
+func TestMaildir(t *testing.T) {
+
+	md := Maildir{Dir: t.TempDir()}
+	if err := md.Send(Email{From: "noreply@example.com", To: []string{"a@example.com"}, Subject: "hi", HTML: "<p>hi</p>"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for sub, n := range map[string]int{"tmp": 0, "new": 1, "cur": 0} {
+		if l, err := os.ReadDir(filepath.Join(md.Dir, sub)); err != nil {
+			t.Fatal(err)
+		} else if len(l) != n {
+			t.Errorf("%d emails in %s, want %d", len(l), sub, n)
+		}
+	}
+}
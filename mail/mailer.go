@@ -2,65 +2,266 @@ package mail
 
 import (
 	"bytes"
-	"errors"
 	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	ttemplate "text/template"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/msg"
 	"gopkg.in/mail.v2"
 )
 
+// Mailer renders emails into its outbox, and sends them from it
+// through its transport, with the smtp.*, mail.* and outbox.*
+// settings in its configuration. Mailers are safe for concurrent use.
+//
+// The package level functions work on the default Mailer, set by
+// Init, or SetDefault, i.e. by rgm.App. Use New for independent ones.
+type Mailer struct {
+
+	// Settings are read from cf, the default configuration if nil.
+	cf *viper.Viper
+
+	outbox Outbox
+
+	// The transport set by SetTransport, guarded by transportMu.
+	// nil means the one set with mail.transport.
+	transport   Transport
+	transportMu sync.RWMutex
+
+	// Wakes Work up when entries are added or resent.
+	wake chan struct{}
+}
+
+// The default Mailer, with an in-memory outbox
+// and the default configuration.
+var mailer = New(nil, nil)
+
+// New returns a Mailer with the settings in cf, adding
+// the emails to ob, or to an in-memory outbox if nil.
+func New(cf *viper.Viper, ob Outbox) *Mailer {
+
+	if ob == nil {
+		ob = NewMemOutbox()
+	}
+	return &Mailer{cf: cf, outbox: ob, wake: make(chan struct{}, 1)}
+}
+
+// Default returns the Mailer the package level functions work on.
+func Default() *Mailer {
+
+	return mailer
+}
+
+// SetDefault sets m as the Mailer the package level functions work on.
+func SetDefault(m *Mailer) {
+
+	mailer = m
+}
+
+// Of returns the Mailer set in the "Mailer" context key,
+// i.e. by rgm.App, or the default one.
+func Of(c *gin.Context) *Mailer {
+
+	if v, exists := c.Get("Mailer"); !exists {
+		return mailer
+	} else if v, ok := v.(*Mailer); ok {
+		return v
+	}
+	return mailer
+}
+
+// Returns the configuration m reads its settings from.
+func (m *Mailer) conf() *viper.Viper {
+
+	if m.cf != nil {
+		return m.cf
+	}
+	return config.Config()
+}
+
 // Message exported
+// The HTML body is rendered from the tpl/email/Tpl template, and the
+// text/plain alternative from its .txt.tpl sibling, i.e. pin.txt.tpl
+// for pin.tpl, or generated from the HTML if there's none.
 type Message struct {
-	To      string
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
 	Subject string
 	Tpl     string
 	Data    interface{}
+
+	// Files attached to the email, check AttachFile.
+	Attachments []Attachment
+
+	// Images embedded in the email, referenced
+	// from the template as cid:Name.
+	Inline []Attachment
+
+	// The email carries secrets, i.e. a pin or a sign-in link.
+	// Its content is kept encrypted in the outbox, and redacted
+	// once sent or dead, for it can't be resent.
+	Sensitive bool
 }
 
 // Send exported
-func (msg *Message) Send(iteration int) {
-
-	if iteration > config.Config().GetInt("smtp.retries") {
-		glog.Error(errors.New("could not send email"))
-		glog.Error(errors.New("exceeded retries"))
-		glog.Flush()
-		return
-	}
-
-	go func(msg *Message, iteration int) {
-
-		var (
-			c    = config.Config()
-			t, _ = template.New(msg.Tpl).ParseFiles("tpl/email/" + msg.Tpl)
-			d    *mail.Dialer
-			m    *mail.Message
-			tpl  bytes.Buffer
-		)
-
-		if err := t.Execute(&tpl, msg.Data); err != nil {
-			glog.Error(errors.New("could not send email"))
-			glog.Error(err)
-			glog.Flush()
-			return
-		}
+// The email is sent with the default Mailer, check Mailer.Send.
+func (msg *Message) Send() error {
 
-		m = mail.NewMessage()
-		m.SetHeader("From", c.GetString("smtp.user"))
-		m.SetHeader("To", msg.To)
-		m.SetHeader("Subject", msg.Subject)
-		m.SetBody("text/html", tpl.String())
-		d = mail.NewDialer(c.GetString("smtp.host"), c.GetInt("smtp.port"),
-			c.GetString("smtp.user"), c.GetString("smtp.password"))
-		d.Timeout = c.GetDuration("smtp.timeout")
-		if err := d.DialAndSend(m); err != nil {
-			glog.Error(errors.New("*mail.Dialer.DialAndSend error"))
-			glog.Error(err)
-			glog.Flush()
-			duration, _ := time.ParseDuration(config.Config().GetString("smtp.retry_interval"))
-			time.Sleep(duration)
-			msg.Send(iteration + 1)
+	return mailer.Send(msg)
+}
+
+// Send renders msg and adds it to the outbox, to be sent
+// in the background by Work. Template errors are returned
+// as *TemplateError.
+func (m *Mailer) Send(msg *Message) error {
+
+	e, err := msg.render(m.from())
+	if err != nil {
+		return err
+	} else if msg.Sensitive {
+		if e, err = m.seal(e); err != nil {
+			return err
 		}
-	}(msg, iteration)
+	}
+
+	now := time.Now()
+	err = m.outbox.Add(Entry{
+		ID:      lib.RandString(32),
+		Email:   e,
+		Status:  Pending,
+		Next:    now,
+		Created: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.wakeUp()
+	return nil
+}
+
+// Renders msg's templates into an Email sent from from.
+func (m *Message) render(from string) (e Email, err error) {
+
+	if len(m.To)+len(m.Cc)+len(m.Bcc) == 0 {
+		return e, &NoRecipients{msg.Get("79")}
+	}
+
+	e = Email{
+		From:        from,
+		To:          m.To,
+		Cc:          m.Cc,
+		Bcc:         m.Bcc,
+		ReplyTo:     m.ReplyTo,
+		Subject:     m.Subject,
+		Attachments: m.Attachments,
+		Inline:      m.Inline,
+	}
+
+	var (
+		path = "tpl/email/" + m.Tpl
+		txt  = strings.TrimSuffix(path, ".tpl") + ".txt.tpl"
+		buf  bytes.Buffer
+	)
+
+	// html body
+	if t, err := template.New(m.Tpl).ParseFiles(path); err != nil {
+		return e, &TemplateError{msg.Get("78").SetArgs(m.Tpl, err.Error())}
+	} else if err := t.Execute(&buf, m.Data); err != nil {
+		return e, &TemplateError{msg.Get("78").SetArgs(m.Tpl, err.Error())}
+	} else {
+		e.HTML = buf.String()
+	}
+
+	// text body
+	if _, err := os.Stat(txt); err != nil {
+		e.Text = textFromHTML(e.HTML)
+		return e, nil
+	}
+
+	buf.Reset()
+	if t, err := ttemplate.New(filepath.Base(txt)).ParseFiles(txt); err != nil {
+		return e, &TemplateError{msg.Get("78").SetArgs(filepath.Base(txt), err.Error())}
+	} else if err := t.Execute(&buf, m.Data); err != nil {
+		return e, &TemplateError{msg.Get("78").SetArgs(filepath.Base(txt), err.Error())}
+	} else {
+		e.Text = buf.String()
+	}
+
+	return e, nil
+}
+
+// AttachFile returns the file at path as an Attachment
+// named after its base name.
+func AttachFile(path string) (Attachment, error) {
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Name: filepath.Base(path), Data: b}, nil
+}
+
+// Returns e as a MIME message.
+func compose(e Email) *mail.Message {
+
+	m := mail.NewMessage()
+	m.SetHeader("From", e.From)
+	if len(e.To) > 0 {
+		m.SetHeader("To", e.To...)
+	}
+	if len(e.Cc) > 0 {
+		m.SetHeader("Cc", e.Cc...)
+	}
+	if len(e.Bcc) > 0 {
+		m.SetHeader("Bcc", e.Bcc...)
+	}
+	if e.ReplyTo != "" {
+		m.SetHeader("Reply-To", e.ReplyTo)
+	}
+	m.SetHeader("Subject", e.Subject)
+
+	if e.Text != "" {
+		m.SetBody("text/plain", e.Text)
+		m.AddAlternative("text/html", e.HTML)
+	} else {
+		m.SetBody("text/html", e.HTML)
+	}
+
+	for _, a := range e.Attachments {
+		m.AttachReader(a.Name, bytes.NewReader(a.Data), a.settings()...)
+	}
+	for _, a := range e.Inline {
+		m.EmbedReader(a.Name, bytes.NewReader(a.Data), a.settings()...)
+	}
+
+	return m
+}
+
+// Sets a's content type, if any.
+func (a Attachment) settings() []mail.FileSetting {
+
+	if a.ContentType == "" {
+		return nil
+	}
+	return []mail.FileSetting{mail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}})}
+}
+
+// Returns the sender address, smtp.from or smtp.user if not set.
+func (m *Mailer) from() string {
+
+	if f := m.conf().GetString("smtp.from"); f != "" {
+		return f
+	}
+	return m.conf().GetString("smtp.user")
 }
@@ -0,0 +1,252 @@
+package mail
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zicare/rgm/msg"
+)
+
+// Status of an Entry in the outbox.
+type Status string
+
+const (
+	// Pending entries are sent once due, check Entry.Next.
+	Pending Status = "pending"
+	// Sent entries are kept until purged, check Work.
+	// Their content is redacted, check Email.Redacted.
+	Sent Status = "sent"
+	// Dead entries exhausted their attempts, check Resend.
+	// Sensitive ones' content is redacted.
+	Dead Status = "dead"
+)
+
+// Email is a rendered Message, ready to be sent.
+type Email struct {
+	From        string       `json:"from"`
+	To          []string     `json:"to"`
+	Cc          []string     `json:"cc,omitempty"`
+	Bcc         []string     `json:"bcc,omitempty"`
+	ReplyTo     string       `json:"reply_to,omitempty"`
+	Subject     string       `json:"subject"`
+	HTML        string       `json:"html"`
+	Text        string       `json:"text,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Inline      []Attachment `json:"inline,omitempty"`
+
+	// The encrypted content of sensitive emails, i.e. carrying a pin,
+	// check Message.Sensitive. HTML, Text and attachments are empty.
+	Sealed string `json:"sealed,omitempty"`
+
+	// The content was removed once sent, or dead if sensitive,
+	// keeping the headers. Redacted emails can't be resent.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Attachment is a file attached to an email, or embedded in it
+// if inline, to be referenced from the HTML as cid:Name.
+type Attachment struct {
+	Name string `json:"name"`
+
+	// Optional, guessed from Name's extension if empty.
+	ContentType string `json:"content_type,omitempty"`
+
+	Data []byte `json:"data"`
+}
+
+// Entry is an Email in the outbox, along its delivery state.
+type Entry struct {
+	ID       string    `json:"id"`
+	Email    Email     `json:"email"`
+	Status   Status    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Summary returns e with no email content, i.e. to be listed.
+func (e Entry) Summary() Entry {
+
+	e.Email = e.Email.redact()
+	return e
+}
+
+// Outbox keeps the emails until they are sent.
+// Outboxes shared by several instances, i.e. SQL backed ones,
+// let them survive restarts and share the sending work.
+type Outbox interface {
+
+	// Add saves e.
+	Add(e Entry) error
+
+	// Claim returns up to n Pending entries due at t, the earliest
+	// first, setting their Next to lease for no other worker to
+	// claim them meanwhile, atomically.
+	Claim(n int, t time.Time, lease time.Time) ([]Entry, error)
+
+	// Save updates e's email, status, attempts, next attempt and error.
+	Save(e Entry) error
+
+	// Get returns the entry matching id, *NotFoundError if none.
+	Get(id string) (Entry, error)
+
+	// List returns the entries with status s, the latest first.
+	List(s Status) ([]Entry, error)
+
+	// Purge removes the Sent entries created before t.
+	Purge(t time.Time) error
+}
+
+// Init sets a Mailer with ob as the default one, check New.
+// Message.Send adds the emails to ob, and Work sends them from it.
+func Init(ob Outbox) {
+
+	mailer = New(nil, ob)
+}
+
+// List returns the entries in the default Mailer's outbox with status s.
+func List(s Status) ([]Entry, error) {
+
+	return mailer.List(s)
+}
+
+// List returns the entries in the outbox with status s, the latest first.
+func (m *Mailer) List(s Status) ([]Entry, error) {
+
+	return m.outbox.List(s)
+}
+
+// Resend resends the Dead entry matching id in the default Mailer.
+func Resend(id string) error {
+
+	return mailer.Resend(id)
+}
+
+// Resend sets the Dead entry matching id Pending again,
+// with no attempts, to be sent right away.
+func (m *Mailer) Resend(id string) error {
+
+	e, err := m.outbox.Get(id)
+	if err != nil {
+		return err
+	} else if e.Status != Dead {
+		return &NotDeadError{msg.Get("76")}
+	} else if e.Email.Redacted {
+		return &RedactedError{msg.Get("80")}
+	}
+
+	e.Status, e.Attempts, e.Next, e.Error = Pending, 0, time.Now(), ""
+	if err := m.outbox.Save(e); err != nil {
+		return err
+	}
+
+	m.wakeUp()
+	return nil
+}
+
+// In-memory Outbox, guarded by mu.
+// Entries are lost on restart.
+type memOutbox struct {
+	mu sync.Mutex
+	m  map[string]Entry
+}
+
+// NewMemOutbox returns an in-memory Outbox, the default one.
+func NewMemOutbox() Outbox {
+
+	return &memOutbox{m: map[string]Entry{}}
+}
+
+func (mo *memOutbox) Add(e Entry) error {
+
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	mo.m[e.ID] = e
+	return nil
+}
+
+func (mo *memOutbox) Claim(n int, t time.Time, lease time.Time) ([]Entry, error) {
+
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	due := []Entry{}
+	for _, e := range mo.m {
+		if e.Status == Pending && !e.Next.After(t) {
+			due = append(due, e)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Next.Before(due[j].Next)
+	})
+	if len(due) > n {
+		due = due[:n]
+	}
+
+	for _, e := range due {
+		e.Next = lease
+		mo.m[e.ID] = e
+	}
+
+	return due, nil
+}
+
+func (mo *memOutbox) Save(e Entry) error {
+
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	if _, ok := mo.m[e.ID]; !ok {
+		return &NotFoundError{msg.Get("18")}
+	}
+	mo.m[e.ID] = e
+	return nil
+}
+
+func (mo *memOutbox) Get(id string) (Entry, error) {
+
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	if e, ok := mo.m[id]; ok {
+		return e, nil
+	}
+	return Entry{}, &NotFoundError{msg.Get("18")}
+}
+
+func (mo *memOutbox) List(s Status) ([]Entry, error) {
+
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	l := []Entry{}
+	for _, e := range mo.m {
+		if e.Status == s {
+			l = append(l, e)
+		}
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Created.After(l[j].Created)
+	})
+
+	return l, nil
+}
+
+func (mo *memOutbox) Purge(t time.Time) error {
+
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	for id, e := range mo.m {
+		if e.Status == Sent && e.Created.Before(t) {
+			delete(mo.m, id)
+		}
+	}
+
+	return nil
+}
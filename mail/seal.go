@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// The parts of an Email carrying its content, sealed for sensitive ones.
+type content struct {
+	HTML        string       `json:"html"`
+	Text        string       `json:"text,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Inline      []Attachment `json:"inline,omitempty"`
+}
+
+// Returns e with its content encrypted into Sealed,
+// with a key derived from the hmac_key setting.
+func (m *Mailer) seal(e Email) (Email, error) {
+
+	gcm, err := m.aead()
+	if err != nil {
+		return e, err
+	}
+
+	plain, err := json.Marshal(content{e.HTML, e.Text, e.Attachments, e.Inline})
+	if err != nil {
+		return e, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return e, err
+	}
+
+	e.HTML, e.Text, e.Attachments, e.Inline = "", "", nil, nil
+	e.Sealed = base64.RawStdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, []byte(e.Subject)))
+	return e, nil
+}
+
+// Returns e with its Sealed content decrypted, e itself if not sealed.
+func (m *Mailer) open(e Email) (Email, error) {
+
+	if e.Sealed == "" {
+		return e, nil
+	}
+
+	gcm, err := m.aead()
+	if err != nil {
+		return e, err
+	}
+
+	b, err := base64.RawStdEncoding.DecodeString(e.Sealed)
+	if err != nil {
+		return e, err
+	} else if len(b) < gcm.NonceSize() {
+		return e, errors.New("sealed email too short")
+	}
+
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(e.Subject))
+	if err != nil {
+		return e, err
+	}
+
+	var c content
+	if err := json.Unmarshal(plain, &c); err != nil {
+		return e, err
+	}
+
+	e.HTML, e.Text, e.Attachments, e.Inline, e.Sealed = c.HTML, c.Text, c.Attachments, c.Inline, ""
+	return e, nil
+}
+
+func (m *Mailer) aead() (cipher.AEAD, error) {
+
+	key := sha256.Sum256([]byte("outbox:" + m.conf().GetString("hmac_key")))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns e with no content, attachments' data
+// nor sealed content, keeping its headers.
+func (e Email) redact() Email {
+
+	strip := func(l []Attachment) []Attachment {
+		if len(l) == 0 {
+			return nil
+		}
+		r := make([]Attachment, len(l))
+		for i, a := range l {
+			r[i] = Attachment{Name: a.Name, ContentType: a.ContentType}
+		}
+		return r
+	}
+
+	e.HTML, e.Text, e.Sealed = "", "", ""
+	e.Attachments, e.Inline = strip(e.Attachments), strip(e.Inline)
+	return e
+}
@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Elements with no readable text.
+	reHidden = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+	// Links, kept as their text followed by the url.
+	reLink = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	// Line breaks, block elements and list items.
+	reBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+	reBlock = regexp.MustCompile(`(?i)</?(p|div|h[1-6]|table|tr|ul|ol|blockquote|hr)\b[^>]*>`)
+	reItem  = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	// Any other tag, and comments.
+	reTag = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+	// Blank lines in excess.
+	reBlank = regexp.MustCompile(`\n{3,}`)
+)
+
+// Returns a text/plain rendition of h, an HTML body,
+// keeping its paragraphs, list items and link urls.
+func textFromHTML(h string) string {
+
+	s := reHidden.ReplaceAllString(h, "")
+	s = reLink.ReplaceAllStringFunc(s, func(a string) string {
+		m := reLink.FindStringSubmatch(a)
+		text := strings.TrimSpace(reTag.ReplaceAllString(m[2], ""))
+		if text == "" || text == m[1] {
+			return m[1]
+		}
+		return text + " (" + m[1] + ")"
+	})
+	s = reBreak.ReplaceAllString(s, "\n")
+	s = reBlock.ReplaceAllString(s, "\n\n")
+	s = reItem.ReplaceAllString(s, "\n- ")
+	s = reTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.Join(strings.Fields(l), " ")
+	}
+
+	return strings.TrimSpace(reBlank.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
@@ -0,0 +1,123 @@
+package mail
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/msg"
+	"gopkg.in/mail.v2"
+)
+
+// Transport delivers rendered emails, i.e. through an SMTP server.
+type Transport interface {
+	Send(e Email) error
+}
+
+// SetTransport sets t as the default Mailer's Transport.
+func SetTransport(t Transport) {
+
+	mailer.SetTransport(t)
+}
+
+// SetTransport sets t as the Transport emails are sent through,
+// i.e. a Capture in tests. A nil t restores the one set with
+// mail.transport.
+func (m *Mailer) SetTransport(t Transport) {
+
+	m.transportMu.Lock()
+	defer m.transportMu.Unlock()
+
+	m.transport = t
+}
+
+// Returns the Transport set by SetTransport, or the one set with
+// mail.transport: "smtp", the default, "file" or "maildir", the
+// last two writing to mail.dir.
+func (m *Mailer) current() (Transport, error) {
+
+	m.transportMu.RLock()
+	t := m.transport
+	m.transportMu.RUnlock()
+
+	if t != nil {
+		return t, nil
+	}
+
+	cf := m.conf()
+	switch k := cf.GetString("mail.transport"); k {
+	case "", "smtp":
+		return NewSMTP(cf), nil
+	case "file":
+		return File{Dir: cf.GetString("mail.dir")}, nil
+	case "maildir":
+		return Maildir{Dir: cf.GetString("mail.dir")}, nil
+	default:
+		return nil, &UnknownTransport{msg.Get("77").SetArgs(k)}
+	}
+}
+
+// Sends e through the current Transport.
+func (m *Mailer) send(e Email) error {
+
+	t, err := m.current()
+	if err != nil {
+		return err
+	}
+	return t.Send(e)
+}
+
+// SMTP sends emails through an SMTP server.
+type SMTP struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Timeout  time.Duration
+
+	// "implicit" for TLS from the start, usually on port 465,
+	// "starttls" to require upgrading with STARTTLS, "opportunistic"
+	// to upgrade if supported, or "none". If empty, implicit TLS is
+	// used on port 465, opportunistic STARTTLS otherwise.
+	TLS string
+
+	// Optional, i.e. to trust a private CA.
+	TLSConfig *tls.Config
+}
+
+// NewSMTP returns the SMTP Transport set with the smtp.* settings in cf.
+func NewSMTP(cf *viper.Viper) SMTP {
+
+	return SMTP{
+		Host:     cf.GetString("smtp.host"),
+		Port:     cf.GetInt("smtp.port"),
+		User:     cf.GetString("smtp.user"),
+		Password: cf.GetString("smtp.password"),
+		Timeout:  cf.GetDuration("smtp.timeout"),
+		TLS:      cf.GetString("smtp.tls"),
+	}
+}
+
+// Send exported
+func (s SMTP) Send(e Email) error {
+
+	d := mail.NewDialer(s.Host, s.Port, s.User, s.Password)
+	d.Timeout = s.Timeout
+
+	switch s.TLS {
+	case "implicit":
+		d.SSL = true
+	case "starttls":
+		d.SSL, d.StartTLSPolicy = false, mail.MandatoryStartTLS
+	case "opportunistic":
+		d.SSL, d.StartTLSPolicy = false, mail.OpportunisticStartTLS
+	case "none":
+		d.SSL, d.StartTLSPolicy = false, mail.NoStartTLS
+	}
+
+	if s.TLSConfig != nil {
+		d.TLSConfig = s.TLSConfig
+	}
+
+	return d.DialAndSend(compose(e))
+}
@@ -0,0 +1,230 @@
+package mail
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Wakes Work up when entries are added or resent.
+func (m *Mailer) wakeUp() {
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Work sends the due entries in the default Mailer's outbox.
+func Work(ctx context.Context) {
+
+	mailer.Work(ctx)
+}
+
+// Work sends the due entries in the outbox with outbox.workers concurrent
+// senders, 4 if not set, until ctx is done. The outbox is polled every
+// outbox.poll_interval, 10s if not set, and right away when emails are
+// added. Claimed entries are leased for outbox.lease, 5m if not set.
+// Failed attempts are retried with exponential back-off, from
+// smtp.retry_interval up to outbox.max_backoff, 1h if not set, and the
+// entry is set Dead after smtp.retries attempts. Sent entries are purged
+// after outbox.retention, 168h if not set.
+// In-flight deliveries are completed before returning.
+func (m *Mailer) Work(ctx context.Context) {
+
+	var (
+		c         = m.conf()
+		n         = c.GetInt("outbox.workers")
+		poll      = c.GetDuration("outbox.poll_interval")
+		retention = c.GetDuration("outbox.retention")
+		jobs      = make(chan Entry)
+		wg        sync.WaitGroup
+		purged    time.Time
+	)
+
+	if n <= 0 {
+		n = 4
+	}
+	if poll <= 0 {
+		poll = 10 * time.Second
+	}
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				m.deliver(e)
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for {
+		now := time.Now()
+
+		if now.Sub(purged) >= time.Hour {
+			if err := m.outbox.Purge(now.Add(-retention)); err != nil {
+				glog.Error(err)
+				glog.Flush()
+			}
+			purged = now
+		}
+
+		claimed, err := m.outbox.Claim(n, now, now.Add(m.lease()))
+		if err != nil {
+			glog.Error(err)
+			glog.Flush()
+		}
+		for _, e := range claimed {
+			jobs <- e
+		}
+
+		// more entries might be due
+		if len(claimed) == n {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Drain drains the default Mailer's outbox.
+func Drain(ctx context.Context) error {
+
+	return mailer.Drain(ctx)
+}
+
+// Drain makes an attempt to send every entry due in the outbox, i.e. on
+// shutdown once Work returned, until there are none left or ctx is done,
+// in which case ctx's error is returned. Entries waiting to be retried
+// are left in the outbox.
+func (m *Mailer) Drain(ctx context.Context) error {
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		claimed, err := m.outbox.Claim(10, now, now.Add(m.lease()))
+		if err != nil {
+			return err
+		} else if len(claimed) == 0 {
+			return nil
+		}
+
+		for _, e := range claimed {
+			m.deliver(e)
+		}
+	}
+}
+
+// Makes an attempt to send e and saves the outcome.
+// The content of sent entries, and of sensitive dead ones,
+// is redacted, check Email.Redacted.
+func (m *Mailer) deliver(e Entry) {
+
+	e.Attempts++
+
+	if e.Email.From == "" {
+		e.Email.From = m.from()
+	}
+
+	if email, err := m.open(e.Email); err != nil {
+
+		glog.Errorf("could not open email %s: %v", e.ID, err)
+		glog.Flush()
+
+		e.Status, e.Error = Dead, err.Error()
+
+	} else if err := m.send(email); err != nil {
+
+		glog.Errorf("could not send email %s to %v, attempt %d: %v", e.ID, e.Email.To, e.Attempts, err)
+		glog.Flush()
+
+		e.Error = err.Error()
+		if e.Attempts >= m.retries() {
+			e.Status = Dead
+		} else {
+			e.Next = time.Now().Add(m.backoff(e.Attempts))
+		}
+
+	} else {
+
+		e.Status, e.Error = Sent, ""
+
+	}
+
+	if e.Status == Sent || (e.Status == Dead && e.Email.Sealed != "") {
+		e.Email = e.Email.redact()
+		e.Email.Redacted = true
+	}
+
+	if err := m.outbox.Save(e); err != nil {
+		glog.Error(err)
+		glog.Flush()
+	}
+}
+
+// Attempts made before an entry is set Dead,
+// smtp.retries, no less than one.
+func (m *Mailer) retries() int {
+
+	if n := m.conf().GetInt("smtp.retries"); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Returns the wait after the given failed attempts,
+// smtp.retry_interval doubled on each one, 1m if not set,
+// up to outbox.max_backoff, 1h if not set.
+func (m *Mailer) backoff(attempts int) time.Duration {
+
+	var (
+		d   = m.conf().GetDuration("smtp.retry_interval")
+		max = m.conf().GetDuration("outbox.max_backoff")
+	)
+
+	if d <= 0 {
+		d = time.Minute
+	}
+	if max <= 0 {
+		max = time.Hour
+	}
+
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Returns how long claimed entries are
+// leased for, outbox.lease, 5m if not set.
+func (m *Mailer) lease() time.Duration {
+
+	if d := m.conf().GetDuration("outbox.lease"); d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
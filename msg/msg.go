@@ -1,24 +1,63 @@
 package msg
 
-import "fmt"
+import (
+	"fmt"
 
-var msg map[string]Message
+	"github.com/gin-gonic/gin"
+)
+
+var msg Catalog
+
+// Catalog maps message keys to messages.
+type Catalog map[string]Message
 
 //Init exported
+// The messages become the default catalog, check Get.
 func Init(m []Message) (err error) {
 
-	_init()
+	c, err := NewCatalog(m)
+	if err != nil {
+		return err
+	}
+
+	msg = c
+	return nil
+}
+
+// NewCatalog returns the built-in messages plus m,
+// leaving the default catalog untouched.
+func NewCatalog(m []Message) (Catalog, error) {
+
+	c := builtin()
 
 	//add client messages
 	for _, v := range m {
-		if _, ok := msg[v.Key]; ok {
-			m := Get("1") //Invalid message key
-			return &m
+		if _, ok := c[v.Key]; ok {
+			m := c.Get("1") //Invalid message key
+			return nil, &m
 		}
-		msg[v.Key] = v
+		c[v.Key] = v
 	}
 
-	return nil
+	return c, nil
+}
+
+// Set sets c as the default catalog.
+func Set(c Catalog) {
+
+	msg = c
+}
+
+// Of returns the catalog set in the "Messages"
+// context key, i.e. by rgm.App, or the default one.
+func Of(c *gin.Context) Catalog {
+
+	if v, exists := c.Get("Messages"); !exists {
+		return msg
+	} else if v, ok := v.(Catalog); ok {
+		return v
+	}
+	return msg
 }
 
 //New exported
@@ -34,15 +73,22 @@ func GetAll() map[string]Message {
 //Get exported
 func Get(key string) Message {
 
-	if message, ok := msg[key]; ok {
+	return msg.Get(key)
+}
+
+// Get returns the message matching key, or
+// a generic one if there's none.
+func (c Catalog) Get(key string) Message {
+
+	if message, ok := c[key]; ok {
 		return message
 	}
 	return New(key, fmt.Sprintf("Message %v", key))
 }
 
-func _init() {
+func builtin() Catalog {
 
-	msg = make(map[string]Message)
+	msg := make(Catalog)
 
 	msg["1"] = New("1", "Invalid message key")
 	msg["2"] = New("2", "%s tags not properly set")
@@ -87,5 +133,45 @@ func _init() {
 	msg["41"] = New("41", "%s resource(s) updated!")
 	msg["42"] = New("42", "Can't add or update a child resource, revise parent's keys.")
 	msg["43"] = New("43", "Duplicated entry.")
+	msg["44"] = New("44", "Token not yet valid")
+	msg["45"] = New("45", "Invalid token issuer")
+	msg["46"] = New("46", "Invalid token audience")
+	msg["47"] = New("47", "Insufficient scope, %s required")
+	msg["48"] = New("48", "API key required")
+	msg["49"] = New("49", "HMAC signed request required")
+	msg["50"] = New("50", "Request timestamp out of the allowed window")
+	msg["51"] = New("51", "Request nonce already used")
+	msg["52"] = New("52", "Invalid request signature")
+	msg["53"] = New("53", "Verified client certificate required")
+	msg["54"] = New("54", "Too many failed attempts, access locked until %s")
+	msg["55"] = New("55", "Lockout of %s after %d failed attempts, until %s")
+	msg["56"] = New("56", "Lockout threshold must be between %s and %s")
+	msg["57"] = New("57", "Lockout delay, max delay and window must be positive, max delay not less than delay")
+	msg["58"] = New("58", "Unlocked!")
+	msg["59"] = New("59", "One-time password required")
+	msg["60"] = New("60", "Invalid one-time password")
+	msg["61"] = New("61", "Two-factor authentication disabled!")
+	msg["62"] = New("62", "Account created, check your email to confirm it")
+	msg["63"] = New("63", "Account confirmed!")
+	msg["64"] = New("64", "Password expired, reset it to continue")
+	msg["65"] = New("65", "Password used recently, choose a different one")
+	msg["66"] = New("66", "Too many wrong attempts, request a new PIN")
+	msg["67"] = New("67", "Sign-in link request accepted")
+	msg["68"] = New("68", "Too many sign-in links requested, try again later")
+	msg["69"] = New("69", "Invalid or expired sign-in link")
+	msg["70"] = New("70", "TPS penalty factor must be between %s and %s")
+	msg["71"] = New("71", "Unknown TPS algorithm %s")
+	msg["72"] = New("72", "TPS penalty cleared!")
+	msg["73"] = New("73", "TPS quota set to %v until %s")
+	msg["74"] = New("74", "TPS penalty set until %s")
+	msg["75"] = New("75", "Email queued to be resent")
+	msg["76"] = New("76", "Only dead emails can be resent")
+	msg["77"] = New("77", "Unknown mail transport %s")
+	msg["78"] = New("78", "Email template %s error: %s")
+	msg["79"] = New("79", "Email has no recipients")
+	msg["80"] = New("80", "Request body exceeds %d bytes")
+	msg["80"] = New("80", "Email content was redacted, it can't be resent")
 	//msg["29"] = New("33", "CORS tags are not properly set")
+
+	return msg
 }
@@ -1,7 +1,9 @@
 package mw
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +16,10 @@ import (
 // as key/value pair under the "User" key, meaning the user
 // was successfuly authenticated. If so, it validates
 // if said user TPS is being abused.
+// Limits set with tps.Rule's for the route apply as well.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers are set on every response, and Retry-After as well
+// when rejected.
 func Abuse() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
@@ -22,26 +28,26 @@ func Abuse() gin.HandlerFunc {
 
 			c.AbortWithStatusJSON(
 				http.StatusInternalServerError,
-				msg.Get("5"),
+				msg.Of(c).Get("5"),
 			)
 
 		} else if u, ok := u.(ds.User); !ok {
 
 			c.AbortWithStatusJSON(
 				http.StatusInternalServerError,
-				msg.Get("5"),
+				msg.Of(c).Get("5"),
 			)
 
-		} else if tps.IsEnabled() {
+		} else if ctl := tps.Of(c); ctl != nil {
 
-			// Check for abuse
-			if date := tps.Transaction(u.Type, u.UID, u.TPS); date != nil && date.After(time.Now()) {
-				// TPS limit exceeded
-				c.AbortWithStatusJSON(
-					http.StatusTooManyRequests,
-					msg.Get("10").SetArgs(date),
-				)
+			// Check for abuse, rules for a role apply
+			// to the roles inheriting from it as well
+			roles := u.Roles
+			if len(roles) == 0 {
+				roles = []string{u.Role}
 			}
+			roles = ds.ACLOf(c).Effective(roles...)
+			limit(c, ctl.Check(tps.Key(u.Type, u.UID), u.TPS, c.FullPath(), c.Request.Method, roles))
 
 		} else {
 
@@ -51,3 +57,47 @@ func Abuse() gin.HandlerFunc {
 
 	}
 }
+
+// AnonymousAbuse limits unauthenticated requests, i.e. to the pin
+// endpoints, per client IP, check tps.Anonymous.
+// Response headers are set as for Abuse.
+func AnonymousAbuse() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		if ctl := tps.Of(c); ctl != nil {
+			limit(c, ctl.Anonymous(c.ClientIP(), c.FullPath(), c.Request.Method))
+		} else {
+			c.Next()
+		}
+	}
+}
+
+// Sets the rate limit headers for st, and
+// aborts if st voids access at the time.
+func limit(c *gin.Context, st tps.Status) {
+
+	if st.Op != nil && st.Op.After(time.Now()) {
+		// TPS limit exceeded
+		rateLimitHeaders(c, st.Limit, 0, *st.Op)
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(*st.Op).Seconds())+1))
+		c.AbortWithStatusJSON(
+			http.StatusTooManyRequests,
+			msg.Of(c).Get("10").SetArgs(st.Op),
+		)
+	} else if st.Limit > 0 {
+		rateLimitHeaders(c, st.Limit, st.Remaining, st.Reset)
+	}
+}
+
+// Sets the RateLimit-* headers, reset being sent in seconds from now.
+func rateLimitHeaders(c *gin.Context, limit, remaining int, reset time.Time) {
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds()))))
+}
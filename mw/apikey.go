@@ -0,0 +1,80 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/msg"
+)
+
+// APIKeyAuthentication executes API key authentication.
+// The key is read from the X-API-Key header, or from an
+// Authorization header with the ApiKey scheme, and looked up
+// hashed with each of the configured peppers, for keys stored
+// before a pepper rotation to be found, check lib.HashKeys.
+// If passed, a new key/value pair is stored in the request context.
+// key: "User"
+// value: ds.User
+func APIKeyAuthentication(dsrc ds.IKeyDataSource) gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		if key := apiKey(c); key == "" {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("48"),
+			)
+
+		} else if u, err := keyUser(dsrc, lib.NewCrypto(config.Of(c)).HashKeys(key)); err != nil {
+
+			switch err.(type) {
+			case *ds.InvalidCredentials, *ds.ExpiredCredentials:
+				c.AbortWithStatusJSON(
+					http.StatusUnauthorized,
+					msg.Of(c).Get("4"),
+				)
+			default:
+				c.AbortWithStatusJSON(
+					http.StatusInternalServerError,
+					msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				)
+			}
+
+		} else {
+
+			c.Set("User", u)
+
+			c.Next()
+
+		}
+	}
+}
+
+// Returns the user owning the key, looked up by each of its hashes.
+func keyUser(dsrc ds.IKeyDataSource, hashes []string) (u ds.User, err error) {
+
+	for _, h := range hashes {
+		if u, err = dsrc.Get(h); err == nil {
+			return u, nil
+		} else if _, ok := err.(*ds.InvalidCredentials); !ok {
+			return u, err
+		}
+	}
+	return u, err
+}
+
+func apiKey(c *gin.Context) string {
+
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	} else if a := strings.SplitN(c.GetHeader("Authorization"), " ", 2); len(a) == 2 && a[0] == "ApiKey" {
+		return a[1]
+	}
+	return ""
+}
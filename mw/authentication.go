@@ -3,16 +3,28 @@ package mw
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/ds"
 	"github.com/zicare/rgm/jwt"
 	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/lockout"
 	"github.com/zicare/rgm/msg"
 )
 
 // BasicAuthentication executes HTTP basic authentication.
+// Users whose password expired are asked to reset it.
+// Password hashes made with an outdated algorithm, cost or
+// pepper are replaced on success, check lib.IRehasher.
+// If lockout control is enabled, failed attempts are accounted
+// per username and per IP, and locked out usernames or IPs are
+// rejected until the lockout expires. Both are reset on success,
+// or once SecondFactor passes for users with TOTP enabled.
 // If passed, a new key/value pair is stored in the request context.
 // key: "User"
 // value: ds.User
@@ -24,33 +36,53 @@ func BasicAuthentication(dsrc ds.IUserDataSource, crypto lib.ICrypto) gin.Handle
 
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
-				msg.Get("3"),
+				msg.Of(c).Get("3"),
 			)
 
+		} else if until := lockout.Of(c).Locked(lockoutKeys(c, username)...); until != nil {
+
+			locked(c, *until)
+
 		} else if u, err := dsrc.Get(username); err != nil {
 
 			switch err.(type) {
-			case *ds.InvalidCredentials, *ds.ExpiredCredentials:
-				c.AbortWithStatusJSON(
-					http.StatusUnauthorized,
-					msg.Get("4"),
-				)
+			case *ds.ExpiredCredentials:
+				// only tell the password expired to its owner
+				if u.Active() && u.PwdExpiredWith(config.Of(c)) && crypto.Compare(password, u.Pwd) {
+					c.AbortWithStatusJSON(
+						http.StatusUnauthorized,
+						msg.Of(c).Get("64"),
+					)
+				} else {
+					failed(c, username)
+				}
+			case *ds.InvalidCredentials:
+				failed(c, username)
 			default:
 				c.AbortWithStatusJSON(
 					http.StatusInternalServerError,
-					msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+					msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 				)
 			}
 
 		} else if !crypto.Compare(password, u.Pwd) {
 
-			c.AbortWithStatusJSON(
-				http.StatusUnauthorized,
-				msg.Get("4"),
-			)
+			failed(c, username)
 
 		} else {
 
+			// users with a second factor are reset once it passes
+			if u.TOTP == "" {
+				lockout.Of(c).Reset(lockoutKeys(c, username)...)
+			}
+
+			// upgrade outdated hashes while the password is at hand
+			if r, ok := crypto.(lib.IRehasher); ok && r.NeedsRehash(u.Pwd) {
+				if err := dsrc.RehashPwd(u.UID, crypto.Encode(password)); err != nil {
+					glog.Warning(err)
+				}
+			}
+
 			c.Set("User", u)
 
 			c.Next()
@@ -59,6 +91,34 @@ func BasicAuthentication(dsrc ds.IUserDataSource, crypto lib.ICrypto) gin.Handle
 	}
 }
 
+func lockoutKeys(c *gin.Context, username string) []string {
+
+	return []string{lockout.UsrKey(username), lockout.IPKey(c.ClientIP())}
+}
+
+// Accounts for a failed attempt and aborts.
+func failed(c *gin.Context, username string) {
+
+	if until := lockout.Of(c).Fail(lockoutKeys(c, username)...); until != nil {
+		locked(c, *until)
+	} else {
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			msg.Of(c).Get("4"),
+		)
+	}
+}
+
+// Aborts a locked out attempt.
+func locked(c *gin.Context, until time.Time) {
+
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	c.AbortWithStatusJSON(
+		http.StatusTooManyRequests,
+		msg.Of(c).Get("54").SetArgs(until.Format(time.RFC3339)),
+	)
+}
+
 // JWTAuthentication executes JWT authentication.
 // Token must be correct, not expired and not revoked.
 // If passed, a new key/value pair is stored in the request context.
@@ -72,56 +132,80 @@ func JWTAuthentication() gin.HandlerFunc {
 
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
-				msg.Get("7"),
+				msg.Of(c).Get("7"),
 			)
 
-		} else if payload, err := jwt.Decode(token[1]); err != nil {
+		} else if payload, err := jwt.DecodeWith(config.Of(c), token[1]); err != nil {
 
 			switch err.(type) {
 			case *jwt.InvalidToken:
 				c.AbortWithStatusJSON(
 					http.StatusUnauthorized,
-					msg.Get("12"),
+					msg.Of(c).Get("12"),
 				)
 			case *jwt.InvalidTokenPayload:
 				c.AbortWithStatusJSON(
 					http.StatusUnauthorized,
-					msg.Get("13"),
+					msg.Of(c).Get("13"),
 				)
 			case *jwt.TamperedToken:
 				c.AbortWithStatusJSON(
 					http.StatusUnauthorized,
-					msg.Get("14"),
+					msg.Of(c).Get("14"),
 				)
 			case *jwt.ExpiredToken:
 				c.AbortWithStatusJSON(
 					http.StatusUnauthorized,
-					msg.Get("15"),
+					msg.Of(c).Get("15"),
+				)
+			case *jwt.NotYetValidToken:
+				c.AbortWithStatusJSON(
+					http.StatusUnauthorized,
+					msg.Of(c).Get("44"),
+				)
+			case *jwt.InvalidIssuer:
+				c.AbortWithStatusJSON(
+					http.StatusUnauthorized,
+					msg.Of(c).Get("45"),
+				)
+			case *jwt.InvalidAudience:
+				c.AbortWithStatusJSON(
+					http.StatusUnauthorized,
+					msg.Of(c).Get("46"),
 				)
 			default:
 				c.AbortWithStatusJSON(
 					http.StatusInternalServerError,
-					msg.Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+					msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
 				)
 			}
 
-		} else if revoked := jwt.IsRevoked(payload); revoked {
+		} else if revoked := jwt.Of(c).IsRevoked(payload); revoked {
 
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
-				msg.Get("32"),
+				msg.Of(c).Get("32"),
 			)
 
 		} else {
 
+			// Tokens issued with a single role
+			roles := payload.Roles
+			if len(roles) == 0 && payload.Role != "" {
+				roles = []string{payload.Role}
+			}
+
 			c.Set("User",
 				ds.User{
-					UID:  payload.UID,
-					Type: payload.Type,
-					Role: payload.Role,
-					TPS:  payload.TPS,
-					From: payload.Iat,
-					To:   payload.Exp,
+					UID:    payload.UID,
+					Type:   payload.Type,
+					Role:   payload.Role,
+					Roles:  roles,
+					TPS:    payload.TPS,
+					From:   payload.Iat,
+					To:     payload.Exp,
+					Scopes: payload.Scopes,
+					Claims: payload.Ext,
 				})
 
 			c.Next()
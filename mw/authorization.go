@@ -2,9 +2,11 @@ package mw
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
 	"github.com/zicare/rgm/msg"
 )
 
@@ -12,8 +14,12 @@ import (
 // as key/value pair under the "User" key, meaning the user
 // was successfuly authenticated. If so, validates
 // if said user has a valid entry in the ACL map for
-// the requested endpoint.
-func Authorization() gin.HandlerFunc {
+// the requested endpoint, either through any of the roles
+// held or the roles they inherit from, or through a Permit
+// policy, with no Deny policy holding. Finally validates if the
+// user holds all the scopes required by the endpoint.
+// In gin's debug mode, denials carry the ds.Decision explaining them.
+func Authorization(scopes ...string) gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 
@@ -21,32 +27,44 @@ func Authorization() gin.HandlerFunc {
 
 			c.AbortWithStatusJSON(
 				http.StatusInternalServerError,
-				msg.Get("5"),
+				msg.Of(c).Get("5"),
 			)
 
 		} else if u, ok := u.(ds.User); !ok {
 
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
-				msg.Get("5"),
+				msg.Of(c).Get("5"),
 			)
 
-		} else {
+		} else if d := ds.Evaluate(c, u); !d.Allowed && gin.IsDebugging() {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				struct {
+					msg.Message
+					Decision ds.Decision
+				}{msg.Of(c).Get("8"), d},
+			)
+
+		} else if !d.Allowed {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("8"),
+			)
 
-			g := ds.Grant{
-				Role:   u.Role,
-				Route:  c.FullPath(),
-				Method: c.Request.Method,
-			}
+		} else if missing := lib.Diff(scopes, u.Scopes); len(missing) > 0 {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("47").SetArgs(strings.Join(missing, " ")),
+			)
 
-			if valid := g.Valid(); !valid {
-				c.AbortWithStatusJSON(
-					http.StatusUnauthorized,
-					msg.Get("8"),
-				)
-			}
+		} else {
 
 			c.Next()
+
 		}
 
 	}
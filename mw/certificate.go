@@ -0,0 +1,54 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/msg"
+)
+
+// CertificateAuthentication executes client certificate (mTLS) authentication.
+// The server must be set to request and verify client certificates,
+// i.e. tls.Config.ClientAuth = tls.RequireAndVerifyClientCert.
+// The verified certificate's subject common name is taken
+// as the username to look up in dsrc.
+// If passed, a new key/value pair is stored in the request context.
+// key: "User"
+// value: ds.User
+func CertificateAuthentication(dsrc ds.IUserDataSource) gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		if tls := c.Request.TLS; tls == nil || len(tls.VerifiedChains) == 0 || len(tls.VerifiedChains[0]) == 0 {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("53"),
+			)
+
+		} else if u, err := dsrc.Get(tls.VerifiedChains[0][0].Subject.CommonName); err != nil {
+
+			switch err.(type) {
+			case *ds.InvalidCredentials, *ds.ExpiredCredentials:
+				c.AbortWithStatusJSON(
+					http.StatusUnauthorized,
+					msg.Of(c).Get("4"),
+				)
+			default:
+				c.AbortWithStatusJSON(
+					http.StatusInternalServerError,
+					msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				)
+			}
+
+		} else {
+
+			c.Set("User", u)
+
+			c.Next()
+
+		}
+	}
+}
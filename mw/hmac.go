@@ -0,0 +1,177 @@
+package mw
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/msg"
+	"github.com/zicare/rgm/tps"
+)
+
+// HMACAuthentication executes HMAC request signing authentication.
+// Requests must carry the following headers:
+//
+// Authorization: HMAC <key id>:<signature>
+// X-Timestamp: <unix time in seconds>
+// X-Nonce: <random string, unique per request>
+//
+// signature is lib.Hash of the string to sign using the shared secret,
+// the string to sign being the method, request URI, timestamp, nonce and
+// hex encoded sha256 of the body, separated by "\n".
+// Timestamps off by more than hmac_auth.skew (5m if not set) and reused
+// nonces are rejected, preventing replays.
+// Bodies over hmac_auth.max_body bytes (1MB if not set) are rejected.
+// If passed, a new key/value pair is stored in the request context.
+// key: "User"
+// value: ds.User
+func HMACAuthentication(dsrc ds.ISecretDataSource) gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		var (
+			auth   = strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+			cred   []string
+			ts     = c.GetHeader("X-Timestamp")
+			nonce  = c.GetHeader("X-Nonce")
+			skew   = hmacSkew(config.Of(c))
+			max    = hmacMaxBody(config.Of(c))
+			now    = time.Now()
+			unix   int64
+			err    error
+			secret string
+			u      ds.User
+			body   []byte
+		)
+
+		if len(auth) == 2 && auth[0] == "HMAC" {
+			cred = strings.SplitN(auth[1], ":", 2)
+		}
+
+		if len(cred) != 2 || ts == "" || nonce == "" {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("49"),
+			)
+
+		} else if unix, err = strconv.ParseInt(ts, 10, 64); err != nil || time.Unix(unix, 0).Before(now.Add(-skew)) || time.Unix(unix, 0).After(now.Add(skew)) {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("50"),
+			)
+
+		} else if u, secret, err = dsrc.Get(cred[0]); err != nil {
+
+			switch err.(type) {
+			case *ds.InvalidCredentials, *ds.ExpiredCredentials:
+				c.AbortWithStatusJSON(
+					http.StatusUnauthorized,
+					msg.Of(c).Get("4"),
+				)
+			default:
+				c.AbortWithStatusJSON(
+					http.StatusInternalServerError,
+					msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+				)
+			}
+
+		} else if body, err = readBody(c, max); err != nil && int64(len(body)) >= max {
+
+			c.AbortWithStatusJSON(
+				http.StatusRequestEntityTooLarge,
+				msg.Of(c).Get("80").SetArgs(max),
+			)
+
+		} else if err != nil {
+
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+
+		} else if sig := lib.Hash(stringToSign(c, ts, nonce, body), secret); !hmac.Equal([]byte(sig), []byte(cred[1])) {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("52"),
+			)
+
+		} else if !useNonce(c, cred[0], nonce, skew) {
+
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				msg.Of(c).Get("51"),
+			)
+
+		} else {
+
+			c.Set("User", u)
+
+			c.Next()
+
+		}
+	}
+}
+
+func hmacSkew(cf *viper.Viper) time.Duration {
+
+	if skew := cf.GetDuration("hmac_auth.skew"); skew > 0 {
+		return skew
+	}
+	return 5 * time.Minute
+}
+
+func hmacMaxBody(cf *viper.Viper) int64 {
+
+	if max := cf.GetInt64("hmac_auth.max_body"); max > 0 {
+		return max
+	}
+	return 1 << 20
+}
+
+// Reads up to max bytes of the request body and puts it back for
+// the next handlers. On error, the bytes read so far are returned,
+// max of them if the body is larger.
+func readBody(c *gin.Context, max int64) ([]byte, error) {
+
+	if c.Request.Body == nil {
+		return []byte{}, nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, max))
+	if err != nil {
+		return body, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+func stringToSign(c *gin.Context, ts, nonce string, body []byte) string {
+
+	h := sha256.Sum256(body)
+	return strings.Join([]string{c.Request.Method, c.Request.URL.RequestURI(), ts, nonce, hex.EncodeToString(h[:])}, "\n")
+}
+
+// Registers the nonce of key id in the App's TPS control, and
+// returns true if it wasn't used before. Nonces older than twice
+// the skew can't be replayed since their timestamps fall off the
+// window, so they're kept that long only, check tps.Control.Limit.
+func useNonce(c *gin.Context, id, nonce string, skew time.Duration) bool {
+
+	return tps.Of(c).Limit(tps.Key("hmac", id, nonce), 1, 2*skew) == nil
+}
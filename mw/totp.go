@@ -0,0 +1,134 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/lockout"
+	"github.com/zicare/rgm/msg"
+)
+
+// SecondFactor executes TOTP two-factor authentication,
+// meant to follow BasicAuthentication, check Verify2FA.
+func SecondFactor(dsrc ds.IUserDataSource, crypto lib.ICrypto) gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		if u, ok := c.Get("User"); !ok {
+
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("5"),
+			)
+
+		} else if u, ok := u.(ds.User); !ok {
+
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("5"),
+			)
+
+		} else if Verify2FA(c, dsrc, crypto, u) {
+
+			c.Next()
+
+		}
+	}
+}
+
+// Verify2FA verifies u's second factor, aborting c if it doesn't pass,
+// for SecondFactor and handlers authenticating users by other means,
+// i.e. sign-in links.
+// Users with no TOTP secret set skip it. Otherwise, the TOTP code
+// must be sent in the X-OTP header, and is accepted once, codes
+// for the time step last accepted or before being rejected.
+// A recovery code is accepted in place of the TOTP code, and
+// removed once used.
+// Failed attempts are accounted as for BasicAuthentication,
+// locked out users are rejected, and the lockout is reset
+// once the second factor passes.
+func Verify2FA(c *gin.Context, dsrc ds.IUserDataSource, crypto lib.ICrypto, u ds.User) bool {
+
+	if u.TOTP == "" {
+
+		return true
+
+	} else if otp := c.GetHeader("X-OTP"); otp == "" {
+
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			msg.Of(c).Get("59"),
+		)
+
+	} else if until := lockout.Of(c).Locked(lockoutKeys(c, u.Usr)...); until != nil {
+
+		locked(c, *until)
+
+	} else if step, ok := lib.TOTPStepWith(config.Of(c), u.TOTP, otp); ok {
+
+		if err := dsrc.PatchTOTPStep(u.Usr, step); err == nil {
+			lockout.Of(c).Reset(lockoutKeys(c, u.Usr)...)
+			return true
+		} else if _, ok := err.(*ds.ReplayedOTP); ok {
+			failedOTP(c, u.Usr)
+		} else {
+			c.AbortWithStatusJSON(
+				http.StatusInternalServerError,
+				msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+			)
+		}
+
+	} else if i := recoveryCode(u, otp, crypto); i < 0 {
+
+		failedOTP(c, u.Usr)
+
+	} else if err := dsrc.PatchTOTP(u.Usr, u.TOTP, append(u.Recovery[:i:i], u.Recovery[i+1:]...)); err != nil {
+
+		c.AbortWithStatusJSON(
+			http.StatusInternalServerError,
+			msg.Of(c).Get("25").SetArgs(fmt.Sprintf("%T", err), err.Error()),
+		)
+
+	} else {
+
+		lockout.Of(c).Reset(lockoutKeys(c, u.Usr)...)
+		return true
+
+	}
+
+	return false
+}
+
+// Accounts for a failed second factor attempt and aborts.
+func failedOTP(c *gin.Context, username string) {
+
+	if until := lockout.Of(c).Fail(lockoutKeys(c, username)...); until != nil {
+		locked(c, *until)
+	} else {
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			msg.Of(c).Get("60"),
+		)
+	}
+}
+
+// Returns the index of the recovery code matching otp, or -1.
+// Only otp values shaped as recovery codes are compared,
+// each comparison being as costly as a password's.
+func recoveryCode(u ds.User, otp string, crypto lib.ICrypto) int {
+
+	if !lib.IsRecoveryCode(otp) {
+		return -1
+	}
+
+	for i, h := range u.Recovery {
+		if crypto.Compare(otp, h) {
+			return i
+		}
+	}
+	return -1
+}
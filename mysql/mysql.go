@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
 
 	//required
@@ -14,34 +15,74 @@ import (
 var db *sql.DB
 
 //Init tests the db connection and saves the db handler
-func Init() error {
-
-	var (
-		err  error
-		cf   = config.Config()
-		conn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=Local",
-			cf.GetString("db.user"),
-			cf.GetString("db.password"),
-			cf.GetString("db.host"),
-			cf.GetString("db.port"),
-			cf.GetString("db.name"))
-	)
-
-	db, err = sql.Open("mysql", conn)
+func Init() (err error) {
+
+	db, err = Open(config.Config())
+	return err
+}
+
+// Open opens and tests a db connection with the db.* settings
+// in cf, leaving the default db handler untouched.
+func Open(cf *viper.Viper) (*sql.DB, error) {
+
+	conn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=Local",
+		cf.GetString("db.user"),
+		cf.GetString("db.password"),
+		cf.GetString("db.host"),
+		cf.GetString("db.port"),
+		cf.GetString("db.name"))
+
+	h, err := sql.Open("mysql", conn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = db.Ping()
-	if err != nil {
-		return err
+	if err = h.Ping(); err != nil {
+		h.Close()
+		return nil, err
 	}
 
-	db.SetMaxOpenConns(cf.GetInt("db.max_open_conns"))
+	h.SetMaxOpenConns(cf.GetInt("db.max_open_conns"))
+
+	return h, nil
+}
+
+// IDB can be implemented by ITable's to be queried through
+// their own db handler, i.e. one per rgm.App, instead of the
+// default one returned by Db.
+type IDB interface {
+	DB() *sql.DB
+}
+
+// IConfig can be implemented by ITable's for the data sources
+// built on them to read their settings from their own configuration,
+// i.e. one per rgm.App, instead of the default one.
+type IConfig interface {
+	Config() *viper.Viper
+}
+
+// Returns the configuration of dsrc if it implements IConfig,
+// or nil, for the data sources to fall back to the default one.
+func confOf(dsrc interface{}) *viper.Viper {
 
+	if i, ok := dsrc.(IConfig); ok {
+		return i.Config()
+	}
 	return nil
 }
 
+// Returns the db handler of dsrc if it implements IDB,
+// or the default one.
+func dbOf(dsrc interface{}) *sql.DB {
+
+	if i, ok := dsrc.(IDB); ok {
+		if db := i.DB(); db != nil {
+			return db
+		}
+	}
+	return Db()
+}
+
 //Db returns the db handler
 func Db() *sql.DB {
 
@@ -53,3 +94,14 @@ func Db() *sql.DB {
 	}
 	return db
 }
+
+//Close closes the db handler, if open
+func Close() error {
+
+	if db == nil {
+		return nil
+	}
+	err := db.Close()
+	db = nil
+	return err
+}
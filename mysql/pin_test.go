@@ -0,0 +1,215 @@
+package mysql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+)
+
+// In-memory pins table behind the pinfake driver, by email.
+// Understands the statements issued by pinDataSource.get and delete.
+type fakePin struct {
+	code             string
+	created, expires time.Time
+	attempts         int64
+}
+
+var (
+	fakePins   = map[string]*fakePin{}
+	fakeUpdate int
+	fakeMu     sync.Mutex
+)
+
+func init() {
+
+	sql.Register("pinfake", fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(q string) (driver.Stmt, error) { return fakeStmt(q), nil }
+func (fakeConn) Close() error                          { return nil }
+func (fakeConn) Begin() (driver.Tx, error)             { return nil, errors.New("not supported") }
+
+type fakeStmt string
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	email := args[0].(string)
+	switch {
+	case strings.HasPrefix(string(s), "UPDATE"):
+		if p, ok := fakePins[email]; ok && p.attempts < args[1].(int64) {
+			p.attempts++
+			fakeUpdate++
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(string(s), "DELETE"):
+		_, ok := fakePins[email]
+		delete(fakePins, email)
+		if ok {
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	}
+	return nil, errors.New("unexpected statement " + string(s))
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	rows := &fakeRows{}
+	if p, ok := fakePins[args[0].(string)]; ok {
+		rows.row = []driver.Value{args[0], p.code, p.created, p.expires, p.attempts}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"email", "code", "created", "expiration", "attempts"}
+}
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+
+	if r.row == nil || r.done {
+		return io.EOF
+	}
+	copy(dest, r.row)
+	r.done = true
+	return nil
+}
+
+type pinTable struct {
+	Table
+	db *sql.DB
+}
+
+func (pinTable) Name() string  { return "pins" }
+func (t pinTable) DB() *sql.DB { return t.db }
+
+// Returns a pinDataSource on the pinfake driver, allowing max attempts,
+// holding a pin with code for email.
+func newPins(t *testing.T, max int, email, code string) pinDataSource {
+
+	prev := config.Config()
+	t.Cleanup(func() { config.Set(prev) })
+
+	def := viper.New()
+	def.Set("pepper", "test-pepper")
+	config.Set(def)
+
+	cf := viper.New()
+	cf.Set("account.pins_max_attempts", max)
+
+	db, err := sql.Open("pinfake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	fakeMu.Lock()
+	fakePins = map[string]*fakePin{email: {
+		code:    lib.HashKey(code),
+		created: time.Now().Add(-time.Minute),
+		expires: time.Now().Add(time.Hour),
+	}}
+	fakeUpdate = 0
+	fakeMu.Unlock()
+
+	return pinDataSource{
+		t:  pinTable{db: db},
+		f:  []string{"email", "code", "created", "expiration", "attempts"},
+		cf: cf,
+	}
+}
+
+func TestPinAttemptLimit(t *testing.T) {
+
+	p := newPins(t, 3, "a@example.com", "K7Q2ZX")
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.get("a@example.com", "WRONG1"); err == nil {
+			t.Fatalf("attempt %d: wrong pin accepted", i)
+		} else if _, ok := err.(*ds.InvalidPinError); !ok {
+			t.Fatalf("attempt %d: got %T, want *ds.InvalidPinError", i, err)
+		}
+	}
+
+	// the last attempt invalidates the pin
+	if _, err := p.get("a@example.com", "WRONG1"); err == nil {
+		t.Fatal("wrong pin accepted")
+	} else if _, ok := err.(*ds.PinAttemptsError); !ok {
+		t.Fatalf("got %T, want *ds.PinAttemptsError", err)
+	}
+
+	if _, err := p.get("a@example.com", "K7Q2ZX"); err == nil {
+		t.Fatal("invalidated pin accepted")
+	}
+}
+
+func TestPinRightCode(t *testing.T) {
+
+	p := newPins(t, 3, "a@example.com", "K7Q2ZX")
+
+	if _, err := p.get("a@example.com", "WRONG1"); err == nil {
+		t.Fatal("wrong pin accepted")
+	}
+	// codes are case insensitive
+	if _, err := p.get("a@example.com", "k7q2zx"); err != nil {
+		t.Fatalf("right pin rejected: %T", err)
+	}
+}
+
+func TestPinAttemptLimitConcurrent(t *testing.T) {
+
+	var (
+		p  = newPins(t, 3, "a@example.com", "K7Q2ZX")
+		wg sync.WaitGroup
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.get("a@example.com", "WRONG1")
+		}()
+	}
+	wg.Wait()
+
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	if fakeUpdate > 3 {
+		t.Fatalf("%d attempts accounted, no more than 3 allowed", fakeUpdate)
+	} else if _, ok := fakePins["a@example.com"]; ok {
+		t.Fatal("pin not invalidated once attempts were exhausted")
+	}
+}
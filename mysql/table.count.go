@@ -72,7 +72,7 @@ func (Table) Count(qo *ds.QueryOptions) (count int64, err error) {
 
 	q, args := b.Build()
 
-	if err := Db().QueryRow(q, args...).Scan(&count); err != nil {
+	if err := dbOf(qo.DataSource).QueryRow(q, args...).Scan(&count); err != nil {
 		return 0, err
 	}
 
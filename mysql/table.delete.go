@@ -19,7 +19,7 @@ func (Table) Delete(qo *ds.QueryOptions) (int64, error) {
 
 	b := sqlbuilder.DeleteFrom(t.Name())
 
-	tx, _ := Db().Begin()
+	tx, _ := dbOf(qo.DataSource).Begin()
 
 	// BeforeDelete check
 	if where, err := t.BeforeDelete(qo, tx); err != nil {
@@ -93,7 +93,7 @@ func (Table) Fetch(qo *ds.QueryOptions) (meta ds.ResultSetMeta, data []interface
 	total := 0
 	b.Select(b.As("COUNT(*)", "t"))
 	q, args := b.Build()
-	if err := Db().QueryRow(q, args...).Scan(&total); err != nil {
+	if err := dbOf(qo.DataSource).QueryRow(q, args...).Scan(&total); err != nil {
 		return meta, data, err
 	}
 
@@ -115,7 +115,7 @@ func (Table) Fetch(qo *ds.QueryOptions) (meta ds.ResultSetMeta, data []interface
 	q, args = b.Build()
 
 	// execute query
-	rows, err := Db().Query(q, args...)
+	rows, err := dbOf(qo.DataSource).Query(q, args...)
 	if err != nil {
 		return meta, data, err
 	}
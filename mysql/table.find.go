@@ -47,7 +47,7 @@ func (Table) Find(qo *ds.QueryOptions) (meta ds.ResultSetMeta, data interface{},
 	q, args := b.Build()
 
 	// execute query
-	if err := Db().QueryRow(q, args...).Scan(s.Addr(&t)...); err == sql.ErrNoRows {
+	if err := dbOf(qo.DataSource).QueryRow(q, args...).Scan(s.Addr(&t)...); err == sql.ErrNoRows {
 		return meta, data, new(ds.NotFoundError)
 	}
 
@@ -2,18 +2,32 @@ package mysql
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/huandu/go-sqlbuilder"
+	"github.com/spf13/viper"
+	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/jwt"
 	"github.com/zicare/rgm/lib"
 	"github.com/zicare/rgm/msg"
 )
 
+// Optional user string tags, in select order.
+var userTags = []string{"scopes", "totp", "recovery", "history"}
+
 // MySQL implementation of user.IUserDataSource.
 type userDataSource struct {
 	t ITable
 	f []string
+	o map[string]string
+
+	// JWTs are revoked in r on password changes, check Revoking.
+	r *jwt.Registry
+
+	// Settings are read from cf, the default configuration if nil.
+	cf *viper.Viper
 }
 
 // UserDSFactory returns an object that implements user.IUserDataSource.
@@ -33,32 +47,106 @@ func UserDSFactory(user ds.IDataSource) (ds.IUserDataSource, error) {
 	} else {
 		dsrc.f = f
 		dsrc.t = t
+		dsrc.cf = confOf(t)
 	}
 
+	// Optional user tags
+	dsrc.o = ds.TagValuesOptional(t, "db", "json", append([]string{"pwd_changed", "totp_step"}, userTags...))
+
 	return dsrc, nil
 }
 
+// Revoking returns dsrc revoking the JWTs of the users
+// whose password changes in r, instead of the default registry.
+func (dsrc userDataSource) Revoking(r *jwt.Registry) ds.IUserDataSource {
+
+	dsrc.r = r
+	return dsrc
+}
+
+// Returns the configuration dsrc reads its settings from.
+func (dsrc userDataSource) conf() *viper.Viper {
+
+	if dsrc.cf != nil {
+		return dsrc.cf
+	}
+	return config.Config()
+}
+
 // Get exported
 func (dsrc userDataSource) Get(username string) (ds.User, error) {
 
-	u := ds.User{Type: dsrc.t.Name()}
+	return dsrc.get(dsrc.f[3], username)
+}
+
+// Returns the user whose col equals v.
+// *ds.ExpiredCredentials is returned along the user if it's
+// out of its validity range or its password expired.
+func (dsrc userDataSource) get(col string, v string) (ds.User, error) {
+
+	var (
+		u       = ds.User{Type: dsrc.t.Name()}
+		opt     = make(map[string]*sql.NullString)
+		changed = new(sql.NullTime)
+		f       = append([]string{}, dsrc.f...)
+		dest    = []interface{}{&u.UID, &u.Role, &u.TPS, &u.Usr, &u.Pwd, &u.From, &u.To}
+	)
+
+	if col, ok := dsrc.o["pwd_changed"]; ok {
+		f = append(f, col)
+		dest = append(dest, changed)
+	}
+
+	for _, tag := range userTags {
+		if col, ok := dsrc.o[tag]; ok {
+			opt[tag] = new(sql.NullString)
+			f = append(f, col)
+			dest = append(dest, opt[tag])
+		}
+	}
+	str := func(tag string) string {
+		if v, ok := opt[tag]; ok {
+			return v.String
+		}
+		return ""
+	}
 
 	b := sqlbuilder.NewSelectBuilder()
 	b.From(dsrc.t.Name())
-	b.Select(dsrc.f...)
-	b.Where(b.Equal(dsrc.f[3], username))
+	b.Select(f...)
+	b.Where(b.Equal(col, v))
 	q, args := b.Build()
 
 	// execute query
-	if err := Db().QueryRow(q, args...).Scan(&u.UID, &u.Role, &u.TPS, &u.Usr, &u.Pwd, &u.From, &u.To); err == sql.ErrNoRows {
+	if err := dbOf(dsrc.t).QueryRow(q, args...).Scan(dest...); err == sql.ErrNoRows {
 		return u, new(ds.InvalidCredentials)
 	} else if err != nil {
 		return u, err
 	}
 
+	// comma delimited roles, the first one being the primary role
+	for _, r := range strings.Split(u.Role, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			u.Roles = append(u.Roles, r)
+		}
+	}
+	if len(u.Roles) > 0 {
+		u.Role = u.Roles[0]
+	}
+
+	// space delimited scopes
+	u.Scopes = strings.Fields(str("scopes"))
+
+	// totp secret and space delimited hashed recovery codes
+	u.TOTP = str("totp")
+	u.Recovery = strings.Fields(str("recovery"))
+
+	// last password change and space delimited password history
+	u.Changed = changed.Time
+	u.History = strings.Fields(str("history"))
+
 	// verify if credential are expired
-	now := time.Now()
-	if now.Before(u.From) || now.After(u.To) {
+	if !u.Active() || u.PwdExpiredWith(dsrc.conf()) {
 		return u, new(ds.ExpiredCredentials)
 	}
 
@@ -66,21 +154,203 @@ func (dsrc userDataSource) Get(username string) (ds.User, error) {
 
 }
 
-// PatchPwd exported
-func (dsrc userDataSource) patchPwd(patch *ds.Patch, crypto lib.ICrypto) error {
+// ChangePwd exported
+func (dsrc userDataSource) ChangePwd(uid string, current string, pwd string, crypto lib.ICrypto) error {
+
+	if u, err := dsrc.get(dsrc.f[0], uid); err != nil {
+		// *ds.InvalidCredentials, *ds.ExpiredCredentials
+		return err
+	} else if !crypto.Compare(current, u.Pwd) {
+		return new(ds.InvalidCredentials)
+	} else {
+		return dsrc.patchPwd(u, pwd, crypto)
+	}
+}
+
+// RehashPwd exported
+func (dsrc userDataSource) RehashPwd(uid string, encoded string) error {
+
+	b := sqlbuilder.NewUpdateBuilder()
+	b.Update(dsrc.t.Name())
+	b.Set(b.Assign(dsrc.f[4], encoded))
+	b.Where(b.Equal(dsrc.f[0], uid))
+	q, args := b.Build()
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		if ok, err := dsrc.exists(dsrc.f[0], uid); err != nil {
+			return err
+		} else if !ok {
+			return new(ds.UpdateError)
+		}
+	}
+
+	return nil
+}
+
+// Sets u's password to pwd, unless it's among the last
+// account.pwd_history ones, and revokes u's JWTs.
+func (dsrc userDataSource) patchPwd(u ds.User, pwd string, crypto lib.ICrypto) error {
+
+	n := dsrc.conf().GetInt("account.pwd_history")
+	history := append([]string{u.Pwd}, u.History...)
+	if len(history) > n {
+		history = history[:n]
+	}
+
+	for _, h := range history {
+		if crypto.Compare(pwd, h) {
+			return new(ds.ReusedPassword)
+		}
+	}
+
+	b := sqlbuilder.NewUpdateBuilder()
+	b.Update(dsrc.t.Name())
+	b.Set(b.Assign(dsrc.f[4], crypto.Encode(pwd)))
+	if col, ok := dsrc.o["history"]; ok && n > 1 {
+		if len(history) > n-1 {
+			history = history[:n-1]
+		}
+		b.SetMore(b.Assign(col, strings.Join(history, " ")))
+	}
+	if col, ok := dsrc.o["pwd_changed"]; ok {
+		b.SetMore(b.Assign(col, time.Now()))
+	}
+	b.Where(b.Equal(dsrc.f[0], u.UID))
+	q, args := b.Build()
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		if ok, err := dsrc.exists(dsrc.f[0], u.UID); err != nil {
+			return err
+		} else if !ok {
+			return new(ds.UpdateError)
+		}
+	}
+
+	// tokens issued with the former password
+	if dsrc.r != nil {
+		dsrc.r.Revoke(u.Type, u.UID)
+	} else {
+		jwt.RevokeJWT(u.Type, u.UID)
+	}
+
+	return nil
+}
+
+// Sets the validity range of the pending user matching username,
+// from now to account.lifetime ahead, 100 years if not set.
+func (dsrc userDataSource) activate(username string) error {
+
+	now := time.Now()
+	to := now.AddDate(100, 0, 0)
+	if d := dsrc.conf().GetDuration("account.lifetime"); d > 0 {
+		to = now.Add(d)
+	}
+
+	b := sqlbuilder.NewUpdateBuilder()
+	b.Update(dsrc.t.Name())
+	b.Set(b.Assign(dsrc.f[5], now), b.Assign(dsrc.f[6], to))
+	b.Where(b.Equal(dsrc.f[3], username), dsrc.f[5]+" = "+dsrc.f[6])
+	q, args := b.Build()
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		if ok, err := dsrc.exists(dsrc.f[3], username); err != nil {
+			return err
+		} else if !ok {
+			return new(ds.UpdateError)
+		}
+	}
+
+	return nil
+}
+
+// PatchTOTP sets the TOTP secret and hashed recovery codes for matching username.
+func (dsrc userDataSource) PatchTOTP(username string, secret string, recovery []string) error {
+
+	totp, ok1 := dsrc.o["totp"]
+	rec, ok2 := dsrc.o["recovery"]
+	if !ok1 || !ok2 {
+		err := new(ds.TagError)
+		err.Copy(msg.Get("2").SetArgs("User"))
+		return err
+	}
+
+	b := sqlbuilder.NewUpdateBuilder()
+	b.Update(dsrc.t.Name())
+	b.Set(b.Assign(totp, secret), b.Assign(rec, strings.Join(recovery, " ")))
+	b.Where(b.Equal(dsrc.f[3], username))
+	q, args := b.Build()
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		if ok, err := dsrc.exists(dsrc.f[3], username); err != nil {
+			return err
+		} else if !ok {
+			return new(ds.UpdateError)
+		}
+	}
+
+	return nil
+}
+
+// Reports whether a user whose col equals val is saved.
+// MySQL reports no affected rows when an update leaves the
+// values unchanged, i.e. disabling 2FA for a user who never
+// enabled it, this tells it from no user.
+func (dsrc userDataSource) exists(col string, val interface{}) (bool, error) {
+
+	var n int
+
+	b := sqlbuilder.NewSelectBuilder()
+	b.From(dsrc.t.Name())
+	b.Select("COUNT(*)")
+	b.Where(b.Equal(col, val))
+	q, args := b.Build()
+
+	if err := dbOf(dsrc.t).QueryRow(q, args...).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// PatchTOTPStep records step as the last TOTP time step a code was
+// accepted at for matching username, provided it's after the recorded one.
+func (dsrc userDataSource) PatchTOTPStep(username string, step int64) error {
+
+	col, ok := dsrc.o["totp_step"]
+	if !ok {
+		err := new(ds.TagError)
+		err.Copy(msg.Get("2").SetArgs("User"))
+		return err
+	}
 
 	b := sqlbuilder.NewUpdateBuilder()
 	b.Update(dsrc.t.Name())
-	b.Set(b.Assign(dsrc.f[4], crypto.Encode(patch.Password)))
-	b.Where(b.Equal(dsrc.f[3], patch.Email))
+	b.Set(b.Assign(col, step))
+	b.Where(b.Equal(dsrc.f[3], username), b.Or(b.IsNull(col), b.LessThan(col, step)))
 	q, args := b.Build()
 
-	if res, err := Db().Exec(q, args...); err != nil {
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
 		return err
 	} else if rows, err := res.RowsAffected(); err != nil {
 		return err
 	} else if rows != 1 {
-		return new(ds.UpdateError)
+		return new(ds.ReplayedOTP)
 	}
 
 	return nil
@@ -1,7 +1,10 @@
 package postgres
 
 import (
+	"time"
+
 	"github.com/huandu/go-sqlbuilder"
+	"github.com/lib/pq"
 	"github.com/zicare/rgm/ds"
 	"github.com/zicare/rgm/msg"
 )
@@ -10,6 +13,7 @@ import (
 type aclDataSource struct {
 	t ITable
 	f []string
+	o map[string]string
 }
 
 // UserDSFactory returns an object that implements user.IUserDataSource.
@@ -31,6 +35,9 @@ func AclDSFactory(acl ds.IDataSource) (ds.IAclDataSource, error) {
 		dsrc.t = t
 	}
 
+	// Optional acl tags
+	dsrc.o = ds.TagValuesOptional(t, "db", "json", []string{"deny"})
+
 	return dsrc, nil
 }
 
@@ -41,10 +48,10 @@ func (dsrc aclDataSource) Fetch() (ds.Acl, error) {
 
 	sb := sqlbuilder.NewSelectBuilder()
 	sb.From(dsrc.t.Name())
-	sb.Select(dsrc.f...)
+	sb.Select(dsrc.cols()...)
 	q, args := sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
 
-	rows, err := Db().Query(q, args...)
+	rows, err := dbOf(dsrc.t).Query(q, args...)
 	if err != nil {
 		return m, err
 	}
@@ -53,7 +60,11 @@ func (dsrc aclDataSource) Fetch() (ds.Acl, error) {
 	for rows.Next() {
 		g := ds.Grant{}
 		t := ds.TimeRange{}
-		if err := rows.Scan(&g.Role, &g.Route, &g.Method, &t.From, &t.To); err != nil {
+		dest := []interface{}{&g.Role, &g.Route, &g.Method, &t.From, &t.To}
+		if _, ok := dsrc.o["deny"]; ok {
+			dest = append(dest, &g.Deny)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return m, err
 		}
 		m[g] = t
@@ -61,3 +72,90 @@ func (dsrc aclDataSource) Fetch() (ds.Acl, error) {
 
 	return m, nil
 }
+
+// Insert saves a new grant valid within r.
+func (dsrc aclDataSource) Insert(g ds.Grant, r ds.TimeRange) error {
+
+	b := sqlbuilder.NewInsertBuilder()
+	b.InsertInto(dsrc.t.Name())
+	b.Cols(dsrc.cols()...)
+	if _, ok := dsrc.o["deny"]; ok {
+		b.Values(g.Role, g.Route, g.Method, r.From, r.To, g.Deny)
+	} else if g.Deny {
+		err := new(ds.TagError)
+		err.Copy(msg.Get("2").SetArgs("ACL"))
+		return err
+	} else {
+		b.Values(g.Role, g.Route, g.Method, r.From, r.To)
+	}
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		if me, ok := err.(*pq.Error); ok && me.Code == "23505" { //unique_violation
+			// Duplicated entry
+			return new(ds.DuplicatedEntry)
+		}
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows != 1 {
+		return new(ds.InsertError)
+	}
+
+	return nil
+}
+
+// Expire sets the grant's validity end to at.
+func (dsrc aclDataSource) Expire(g ds.Grant, at time.Time) error {
+
+	b := sqlbuilder.NewUpdateBuilder()
+	b.Update(dsrc.t.Name())
+	b.Set(b.Assign(dsrc.f[4], at))
+	b.Where(b.Equal(dsrc.f[0], g.Role), b.Equal(dsrc.f[1], g.Route), b.Equal(dsrc.f[2], g.Method))
+	if col, ok := dsrc.o["deny"]; ok {
+		b.Where(b.Equal(col, g.Deny))
+	}
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return new(ds.NotFoundError)
+	}
+
+	return nil
+}
+
+// Delete removes the grant.
+func (dsrc aclDataSource) Delete(g ds.Grant) error {
+
+	b := sqlbuilder.NewDeleteBuilder()
+	b.DeleteFrom(dsrc.t.Name())
+	b.Where(b.Equal(dsrc.f[0], g.Role), b.Equal(dsrc.f[1], g.Route), b.Equal(dsrc.f[2], g.Method))
+	if col, ok := dsrc.o["deny"]; ok {
+		b.Where(b.Equal(col, g.Deny))
+	}
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	if res, err := dbOf(dsrc.t).Exec(q, args...); err != nil {
+		return err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return new(ds.NotFoundError)
+	}
+
+	return nil
+}
+
+// Returns the required acl columns plus the optional ones set.
+func (dsrc aclDataSource) cols() []string {
+
+	f := append([]string{}, dsrc.f...)
+	if col, ok := dsrc.o["deny"]; ok {
+		f = append(f, col)
+	}
+	return f
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/msg"
+)
+
+// PostgreSQL implementation of ds.IKeyDataSource.
+type keyDataSource struct {
+	t ITable
+	f []string
+}
+
+// PostgreSQL implementation of ds.ISecretDataSource.
+type secretDataSource struct {
+	t ITable
+	f []string
+}
+
+// KeyDSFactory returns an object that implements ds.IKeyDataSource.
+func KeyDSFactory(key ds.IDataSource) (ds.IKeyDataSource, error) {
+
+	dsrc := keyDataSource{}
+
+	t, ok := key.(ITable)
+	if !ok {
+		return dsrc, new(NotITableError)
+	}
+
+	// Verify key tags
+	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"uid", "role", "tps", "key", "from", "to"}); err != nil {
+		err.Copy(msg.Get("2").SetArgs("Key"))
+		return dsrc, err
+	} else {
+		dsrc.f = f
+		dsrc.t = t
+	}
+
+	return dsrc, nil
+}
+
+// Get returns the active User owning the hashed API key.
+func (dsrc keyDataSource) Get(hash string) (ds.User, error) {
+
+	u := ds.User{Type: dsrc.t.Name()}
+
+	b := sqlbuilder.NewSelectBuilder()
+	b.From(dsrc.t.Name())
+	b.Select(dsrc.f...)
+	b.Where(b.Equal(dsrc.f[3], hash))
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	// execute query
+	if err := dbOf(dsrc.t).QueryRow(q, args...).Scan(&u.UID, &u.Role, &u.TPS, &u.Usr, &u.From, &u.To); err == sql.ErrNoRows {
+		return u, new(ds.InvalidCredentials)
+	} else if err != nil {
+		return u, err
+	}
+
+	// the key hash is no username
+	u.Usr = ""
+	u.Roles = []string{u.Role}
+
+	// verify if credential are expired
+	now := time.Now()
+	if now.Before(u.From) || now.After(u.To) {
+		return u, new(ds.ExpiredCredentials)
+	}
+
+	return u, nil
+}
+
+// SecretDSFactory returns an object that implements ds.ISecretDataSource.
+func SecretDSFactory(secret ds.IDataSource) (ds.ISecretDataSource, error) {
+
+	dsrc := secretDataSource{}
+
+	t, ok := secret.(ITable)
+	if !ok {
+		return dsrc, new(NotITableError)
+	}
+
+	// Verify secret tags
+	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"uid", "role", "tps", "id", "secret", "from", "to"}); err != nil {
+		err.Copy(msg.Get("2").SetArgs("Secret"))
+		return dsrc, err
+	} else {
+		dsrc.f = f
+		dsrc.t = t
+	}
+
+	return dsrc, nil
+}
+
+// Get returns the active User identified by key id and its shared secret.
+func (dsrc secretDataSource) Get(id string) (ds.User, string, error) {
+
+	var (
+		u      = ds.User{Type: dsrc.t.Name()}
+		secret string
+	)
+
+	b := sqlbuilder.NewSelectBuilder()
+	b.From(dsrc.t.Name())
+	b.Select(dsrc.f...)
+	b.Where(b.Equal(dsrc.f[3], id))
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	// execute query
+	if err := dbOf(dsrc.t).QueryRow(q, args...).Scan(&u.UID, &u.Role, &u.TPS, &u.Usr, &secret, &u.From, &u.To); err == sql.ErrNoRows {
+		return u, secret, new(ds.InvalidCredentials)
+	} else if err != nil {
+		return u, secret, err
+	}
+
+	u.Roles = []string{u.Role}
+
+	// verify if credential are expired
+	now := time.Now()
+	if now.Before(u.From) || now.After(u.To) {
+		return u, secret, new(ds.ExpiredCredentials)
+	}
+
+	return u, secret, nil
+}
@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/mail"
+	"github.com/zicare/rgm/msg"
+)
+
+// PostgreSQL implementation of mail.Outbox.
+// Lets emails survive restarts and several instances share the sending work.
+type outbox struct {
+	t ITable
+	f []string
+}
+
+// OutboxFactory returns an object that implements mail.Outbox.
+// The Email of each entry is saved JSON encoded.
+func OutboxFactory(ob ds.IDataSource) (mail.Outbox, error) {
+
+	dsrc := outbox{}
+
+	t, ok := ob.(ITable)
+	if !ok {
+		return dsrc, new(NotITableError)
+	}
+
+	// Verify outbox tags
+	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"id", "email", "status", "attempts", "next", "error", "created"}); err != nil {
+		err.Copy(msg.Get("2").SetArgs("Outbox"))
+		return dsrc, err
+	} else {
+		dsrc.f = f
+		dsrc.t = t
+	}
+
+	return dsrc, nil
+}
+
+// Add exported
+func (dsrc outbox) Add(e mail.Entry) error {
+
+	raw, err := json.Marshal(e.Email)
+	if err != nil {
+		return err
+	}
+
+	b := sqlbuilder.NewInsertBuilder()
+	b.InsertInto(dsrc.t.Name())
+	b.Cols(dsrc.f...)
+	b.Values(e.ID, string(raw), string(e.Status), e.Attempts, e.Next, e.Error, e.Created)
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	_, err = dbOf(dsrc.t).Exec(q, args...)
+	return err
+}
+
+// Claim locks the due rows while leasing them.
+func (dsrc outbox) Claim(n int, t time.Time, lease time.Time) ([]mail.Entry, error) {
+
+	tx, err := dbOf(dsrc.t).Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.From(dsrc.t.Name())
+	sb.Select(dsrc.f...)
+	sb.Where(sb.Equal(dsrc.f[2], string(mail.Pending)), sb.LessEqualThan(dsrc.f[4], t))
+	sb.OrderBy(dsrc.f[4]).Asc()
+	sb.Limit(n)
+	sb.ForUpdate()
+	q, args := sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	l, err := dsrc.query(tx, q, args...)
+	if err != nil || len(l) == 0 {
+		return l, err
+	}
+
+	ids := make([]interface{}, len(l))
+	for i := range l {
+		ids[i] = l[i].ID
+		l[i].Next = lease
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update(dsrc.t.Name())
+	ub.Set(ub.Assign(dsrc.f[4], lease))
+	ub.Where(ub.In(dsrc.f[0], ids...))
+	q, args = ub.BuildWithFlavor(sqlbuilder.PostgreSQL)
+	if _, err := tx.Exec(q, args...); err != nil {
+		return nil, err
+	}
+
+	return l, tx.Commit()
+}
+
+// Save exported
+func (dsrc outbox) Save(e mail.Entry) error {
+
+	raw, err := json.Marshal(e.Email)
+	if err != nil {
+		return err
+	}
+
+	b := sqlbuilder.NewUpdateBuilder()
+	b.Update(dsrc.t.Name())
+	b.Set(
+		b.Assign(dsrc.f[1], string(raw)),
+		b.Assign(dsrc.f[2], string(e.Status)),
+		b.Assign(dsrc.f[3], e.Attempts),
+		b.Assign(dsrc.f[4], e.Next),
+		b.Assign(dsrc.f[5], e.Error),
+	)
+	b.Where(b.Equal(dsrc.f[0], e.ID))
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	_, err = dbOf(dsrc.t).Exec(q, args...)
+	return err
+}
+
+// Get exported
+func (dsrc outbox) Get(id string) (mail.Entry, error) {
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.From(dsrc.t.Name())
+	sb.Select(dsrc.f...)
+	sb.Where(sb.Equal(dsrc.f[0], id))
+	q, args := sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	if l, err := dsrc.query(dbOf(dsrc.t), q, args...); err != nil {
+		return mail.Entry{}, err
+	} else if len(l) == 0 {
+		return mail.Entry{}, &mail.NotFoundError{Message: msg.Get("18")}
+	} else {
+		return l[0], nil
+	}
+}
+
+// List exported
+func (dsrc outbox) List(s mail.Status) ([]mail.Entry, error) {
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.From(dsrc.t.Name())
+	sb.Select(dsrc.f...)
+	sb.Where(sb.Equal(dsrc.f[2], string(s)))
+	sb.OrderBy(dsrc.f[6]).Desc()
+	q, args := sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	return dsrc.query(dbOf(dsrc.t), q, args...)
+}
+
+// Purge exported
+func (dsrc outbox) Purge(t time.Time) error {
+
+	b := sqlbuilder.NewDeleteBuilder()
+	b.DeleteFrom(dsrc.t.Name())
+	b.Where(b.Equal(dsrc.f[2], string(mail.Sent)), b.LessThan(dsrc.f[6], t))
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	_, err := dbOf(dsrc.t).Exec(q, args...)
+	return err
+}
+
+// Implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Returns the entries selected by q, either through the db handler or a tx.
+func (dsrc outbox) query(db querier, q string, args ...interface{}) ([]mail.Entry, error) {
+
+	l := []mail.Entry{}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return l, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e      mail.Entry
+			raw    []byte
+			status string
+		)
+		if err := rows.Scan(&e.ID, &raw, &status, &e.Attempts, &e.Next, &e.Error, &e.Created); err != nil {
+			return l, err
+		} else if err := json.Unmarshal(raw, &e.Email); err != nil {
+			return l, err
+		}
+		e.Status = mail.Status(status)
+		l = append(l, e)
+	}
+
+	return l, rows.Err()
+}
@@ -1,13 +1,16 @@
 package postgres
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"strings"
 	"time"
 
 	"github.com/huandu/go-sqlbuilder"
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/jwt"
 	"github.com/zicare/rgm/lib"
 	"github.com/zicare/rgm/msg"
 )
@@ -16,7 +19,14 @@ import (
 type pinDataSource struct {
 	t ITable
 	f []string
+	o map[string]string
 	u ds.IUserDataSource
+
+	// Pins are issued and verified for purpose only, check For.
+	purpose string
+
+	// Settings are read from cf, the default configuration if nil.
+	cf *viper.Viper
 }
 
 // PinDSFactory returns an object that implements pin.IPinDataSource.
@@ -34,41 +44,153 @@ func PinDSFactory(pin, user ds.IDataSource) (ds.IPinDataSource, error) {
 	}
 
 	// Get pin fields
-	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"email", "code", "created", "expiration"}); err != nil {
+	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"email", "code", "created", "expiration", "attempts"}); err != nil {
 		err.Copy(msg.Get("2").SetArgs("Pin"))
 		return pdsrc, err
 	} else {
 		pdsrc.f = f
 		pdsrc.t = t
+		pdsrc.cf = confOf(t)
 	}
 
+	// Optional pin tags
+	pdsrc.o = ds.TagValuesOptional(t, "db", "json", []string{"purpose"})
+
 	return pdsrc, nil
 }
 
+// Returns the configuration p reads its settings from.
+func (p pinDataSource) conf() *viper.Viper {
+
+	if p.cf != nil {
+		return p.cf
+	}
+	return config.Config()
+}
+
+// For returns p issuing and verifying pins for purpose only,
+// apart from the ones issued for other purposes.
+// p.t must have a purpose tagged field.
+func (p pinDataSource) For(purpose string) (ds.IPinDataSource, error) {
+
+	if _, ok := p.o["purpose"]; !ok {
+		err := new(ds.TagError)
+		err.Copy(msg.Get("2").SetArgs("Pin"))
+		return p, err
+	}
+
+	p.purpose = purpose
+	return p, nil
+}
+
+// Revoking returns p revoking the JWTs of the users
+// whose password is patched in r, check userDataSource.Revoking.
+func (p pinDataSource) Revoking(r *jwt.Registry) ds.IPinDataSource {
+
+	p.u = p.u.(userDataSource).Revoking(r)
+	return p
+}
+
 // Post saves a new pin to p.t.
 // email param must match an active user record in p.u.
 func (p pinDataSource) Post(email string) (ps ds.Pin, err error) {
 
 	// validate email
-	if _, err := p.u.Get(email); err != nil {
+	if _, err := p.active(email); err != nil {
 		return ps, err
 	}
 
+	return p.insert(email)
+}
+
+// Issue saves a new pin to p.t.
+// email param must match a pending user record in p.u.
+func (p pinDataSource) Issue(email string) (ds.Pin, error) {
+
+	if err := p.pending(email); err != nil {
+		return ds.Pin{}, err
+	}
+
+	return p.insert(email)
+}
+
+// Activate sets the validity range of the pending user
+// matching email, making it active.
+// email must match a pending user record in p.u.
+// email, code must match an active pin record in p.
+func (p pinDataSource) Activate(email, code string) error {
+
+	if err := p.pending(email); err != nil {
+		// *user.InvalidCredentials
+		return err
+	} else if _, err := p.get(email, code); err != nil {
+		// *pin.InvalidPinError, *pin.ExpiredPinError
+		return err
+	} else if err := p.u.(userDataSource).activate(email); err != nil {
+		return err
+	}
+	return p.delete(email)
+}
+
+// Returns the user matching email in p.u, provided it's active.
+// Users with an expired password are let through, to reset it.
+func (p pinDataSource) active(email string) (ds.User, error) {
+
+	u, err := p.u.Get(email)
+	if _, ok := err.(*ds.ExpiredCredentials); ok && u.Active() {
+		return u, nil
+	}
+	return u, err
+}
+
+// Returns *ds.InvalidCredentials unless email
+// matches a pending user record in p.u.
+func (p pinDataSource) pending(email string) error {
+
+	if u, err := p.u.Get(email); err == nil {
+		return new(ds.InvalidCredentials)
+	} else if _, ok := err.(*ds.ExpiredCredentials); !ok {
+		return err
+	} else if !u.Pending() {
+		return new(ds.InvalidCredentials)
+	}
+	return nil
+}
+
+// Saves a new pin for email, hashed, invalidating former ones.
+// The returned pin holds the plain code, to be sent.
+func (p pinDataSource) insert(email string) (ps ds.Pin, err error) {
+
+	ttl := p.conf().GetDuration("account.pins_ttl")
+	if ttl <= 0 {
+		ttl = 30 * time.Minute
+	}
+
 	now := time.Now()
 	ps = ds.Pin{
 		Email:      email,
-		Code:       strings.ToUpper(lib.RandString(config.Config().GetInt("account.pins_length"))),
+		Code:       lib.RandCode(p.conf().GetInt("account.pins_length")),
 		Created:    now,
-		Expiration: now.Add(30 * time.Minute),
+		Expiration: now.Add(ttl),
+	}
+
+	// invalidate former pins
+	if err := p.delete(email); err != nil {
+		return ps, err
 	}
 
 	// insert pin
 	b := sqlbuilder.NewInsertBuilder()
 	b.InsertInto(p.t.Name())
-	b.Cols(p.f...)
-	b.Values(ps.Email, ps.Code, ps.Created, ps.Expiration)
+	if col, ok := p.o["purpose"]; ok {
+		b.Cols(append(append([]string{}, p.f...), col)...)
+		b.Values(ps.Email, lib.HashKey(ps.Code), ps.Created, ps.Expiration, 0, p.purpose)
+	} else {
+		b.Cols(p.f...)
+		b.Values(ps.Email, lib.HashKey(ps.Code), ps.Created, ps.Expiration, 0)
+	}
 	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
-	if res, err := Db().Exec(q, args...); err != nil {
+	if res, err := dbOf(p.t).Exec(q, args...); err != nil {
 		return ps, err
 	} else if rows, err := res.RowsAffected(); err != nil {
 		return ps, new(ds.InsertError)
@@ -85,18 +207,38 @@ func (p pinDataSource) Post(email string) (ps ds.Pin, err error) {
 // patch.Email, patch.Pin must match an active pin record in p.
 func (p pinDataSource) PatchPwd(patch *ds.Patch, crypto lib.ICrypto) error {
 
-	if _, err := p.u.Get(patch.Email); err != nil {
+	if u, err := p.active(patch.Email); err != nil {
 		// *user.InvalidCredentials, *user.ExpiredCredentials
 		return err
 	} else if _, err := p.get(patch.Email, patch.Pin); err != nil {
 		// *pin.InvalidPinError, *pin.ExpiredPinError
 		return err
-	} else if err := p.u.(userDataSource).patchPwd(patch, crypto); err != nil {
+	} else if err := p.u.(userDataSource).patchPwd(u, patch.Password, crypto); err != nil {
+		// *ds.ReusedPassword
 		return err
 	}
-	return nil
+	return p.delete(patch.Email)
 }
 
+// Verify checks the pin posted for email.
+// email must match an active user record in p.u.
+// email, code must match an active pin record in p.
+func (p pinDataSource) Verify(email, code string) error {
+
+	if _, err := p.active(email); err != nil {
+		// *user.InvalidCredentials, *user.ExpiredCredentials
+		return err
+	} else if _, err := p.get(email, code); err != nil {
+		// *pin.InvalidPinError, *pin.ExpiredPinError
+		return err
+	}
+	return p.delete(email)
+}
+
+// Returns the pin issued for email, provided code matches it.
+// Attempts are accounted before comparing the code, atomically, and
+// the pin is invalidated after account.pins_max_attempts wrong ones
+// (5 if not set), concurrent attempts included.
 func (p pinDataSource) get(email, code string) (ds.Pin, error) {
 
 	ps := ds.Pin{}
@@ -104,11 +246,13 @@ func (p pinDataSource) get(email, code string) (ds.Pin, error) {
 	b := sqlbuilder.NewSelectBuilder()
 	b.From(p.t.Name())
 	b.Select(p.f...)
-	b.Where(b.Equal(p.f[0], email), b.Equal(p.f[1], code))
+	b.Where(p.match(&b.Cond, email)...)
+	b.OrderBy(p.f[2]).Desc()
+	b.Limit(1)
 	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
 
 	// execute query
-	if err := Db().QueryRow(q, args...).Scan(&ps.Email, &ps.Code, &ps.Created, &ps.Expiration); err == sql.ErrNoRows {
+	if err := dbOf(p.t).QueryRow(q, args...).Scan(&ps.Email, &ps.Code, &ps.Created, &ps.Expiration, &ps.Attempts); err == sql.ErrNoRows {
 		return ps, new(ds.InvalidPinError)
 	} else if err != nil {
 		return ps, err
@@ -119,5 +263,64 @@ func (p pinDataSource) get(email, code string) (ds.Pin, error) {
 		return ps, new(ds.ExpiredPinError)
 	}
 
-	return ps, nil
+	max := p.conf().GetInt("account.pins_max_attempts")
+	if max <= 0 {
+		max = 5
+	}
+
+	// account for the attempt, unless attempts are exhausted
+	u := sqlbuilder.NewUpdateBuilder()
+	u.Update(p.t.Name())
+	u.Set(u.Incr(p.f[4]))
+	u.Where(append(p.match(&u.Cond, email), u.LessThan(p.f[4], max))...)
+	q, args = u.BuildWithFlavor(sqlbuilder.PostgreSQL)
+	if res, err := dbOf(p.t).Exec(q, args...); err != nil {
+		return ps, err
+	} else if rows, err := res.RowsAffected(); err != nil {
+		return ps, err
+	} else if rows == 0 {
+		if err := p.delete(email); err != nil {
+			return ps, err
+		}
+		return ps, new(ds.PinAttemptsError)
+	}
+
+	// pins issued before a pepper rotation are hashed with a former one
+	for _, h := range lib.HashKeys(strings.ToUpper(code)) {
+		if subtle.ConstantTimeCompare([]byte(ps.Code), []byte(h)) == 1 {
+			return ps, nil
+		}
+	}
+
+	if ps.Attempts+1 >= max {
+		if err := p.delete(email); err != nil {
+			return ps, err
+		}
+		return ps, new(ds.PinAttemptsError)
+	}
+
+	return ps, new(ds.InvalidPinError)
+}
+
+// Invalidates the pins issued for email.
+func (p pinDataSource) delete(email string) error {
+
+	b := sqlbuilder.NewDeleteBuilder()
+	b.DeleteFrom(p.t.Name())
+	b.Where(p.match(&b.Cond, email)...)
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	_, err := dbOf(p.t).Exec(q, args...)
+	return err
+}
+
+// Returns the conditions matching the pins issued for email
+// and p.purpose, if p.t keeps pins apart by purpose.
+func (p pinDataSource) match(c *sqlbuilder.Cond, email string) []string {
+
+	w := []string{c.Equal(p.f[0], email)}
+	if col, ok := p.o["purpose"]; ok {
+		w = append(w, c.Equal(col, p.purpose))
+	}
+	return w
 }
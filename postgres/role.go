@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/msg"
+)
+
+// PostgreSQL implementation of ds.IRoleDataSource.
+type roleDataSource struct {
+	t ITable
+	f []string
+}
+
+// RoleDSFactory returns an object that implements ds.IRoleDataSource.
+func RoleDSFactory(role ds.IDataSource) (ds.IRoleDataSource, error) {
+
+	dsrc := roleDataSource{}
+
+	t, ok := role.(ITable)
+	if !ok {
+		return dsrc, new(NotITableError)
+	}
+
+	// Verify role tags
+	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"role", "parent"}); err != nil {
+		err.Copy(msg.Get("2").SetArgs("Role"))
+		return dsrc, err
+	} else {
+		dsrc.f = f
+		dsrc.t = t
+	}
+
+	return dsrc, nil
+}
+
+// Fetch returns all roles mapped to the parent roles they inherit from.
+func (dsrc roleDataSource) Fetch() (ds.Hierarchy, error) {
+
+	m := make(ds.Hierarchy)
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.From(dsrc.t.Name())
+	sb.Select(dsrc.f...)
+	q, args := sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	rows, err := dbOf(dsrc.t).Query(q, args...)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role, parent string
+		if err := rows.Scan(&role, &parent); err != nil {
+			return m, err
+		}
+		m[role] = append(m[role], parent)
+	}
+
+	return m, nil
+}
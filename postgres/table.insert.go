@@ -14,7 +14,7 @@ func (Table) Insert(qo *ds.QueryOptions) error {
 		return new(NotITableError)
 	}
 
-	tx, _ := Db().Begin()
+	tx, _ := dbOf(qo.DataSource).Begin()
 
 	if err := t.BeforeInsert(qo, tx); err != nil {
 		return err
@@ -15,7 +15,7 @@ func (Table) Update(qo *ds.QueryOptions) (int64, error) {
 		return 0, new(NotITableError)
 	}
 
-	tx, _ := Db().Begin()
+	tx, _ := dbOf(qo.DataSource).Begin()
 
 	if err := t.BeforeUpdate(qo, tx); err != nil {
 		return 0, err
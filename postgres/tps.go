@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/msg"
+	"github.com/zicare/rgm/tps"
+)
+
+// PostgreSQL implementation of tps.Store.
+// Lets several instances share TPS control state.
+type tpsStore struct {
+	t ITable
+	f []string
+}
+
+// TPSStoreFactory returns an object that implements tps.Store.
+// The State of each key is saved JSON encoded along the time it
+// turns idle, check tps.State.Idle, for purging.
+func TPSStoreFactory(store ds.IDataSource) (tps.Store, error) {
+
+	dsrc := tpsStore{}
+
+	t, ok := store.(ITable)
+	if !ok {
+		return dsrc, new(NotITableError)
+	}
+
+	// Verify tps tags
+	if f, err := ds.TagValuesPivoted(t, "db", "json", []string{"key", "state", "idle"}); err != nil {
+		err.Copy(msg.Get("2").SetArgs("TPS"))
+		return dsrc, err
+	} else {
+		dsrc.f = f
+		dsrc.t = t
+	}
+
+	return dsrc, nil
+}
+
+// Update locks the row of key while fn is applied.
+func (dsrc tpsStore) Update(key string, fn func(s *tps.State)) error {
+
+	tx, err := dbOf(dsrc.t).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// make sure the row exists
+	ib := sqlbuilder.PostgreSQL.NewInsertBuilder()
+	ib.InsertIgnoreInto(dsrc.t.Name())
+	ib.Cols(dsrc.f...)
+	ib.Values(key, "{}", time.Now())
+	q, args := ib.BuildWithFlavor(sqlbuilder.PostgreSQL)
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.From(dsrc.t.Name())
+	sb.Select(dsrc.f[1])
+	sb.Where(sb.Equal(dsrc.f[0], key))
+	sb.ForUpdate()
+	q, args = sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	var (
+		raw []byte
+		s   tps.State
+	)
+	if err := tx.QueryRow(q, args...).Scan(&raw); err != nil {
+		return err
+	} else if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+
+	fn(&s)
+
+	if raw, err = json.Marshal(s); err != nil {
+		return err
+	}
+
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update(dsrc.t.Name())
+	ub.Set(ub.Assign(dsrc.f[1], string(raw)), ub.Assign(dsrc.f[2], s.Idle()))
+	ub.Where(ub.Equal(dsrc.f[0], key))
+	q, args = ub.BuildWithFlavor(sqlbuilder.PostgreSQL)
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Purge exported
+func (dsrc tpsStore) Purge(t time.Time) error {
+
+	b := sqlbuilder.NewDeleteBuilder()
+	b.DeleteFrom(dsrc.t.Name())
+	b.Where(b.LessThan(dsrc.f[2], t))
+	q, args := b.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	_, err := dbOf(dsrc.t).Exec(q, args...)
+	return err
+}
+
+// List exported
+func (dsrc tpsStore) List() (map[string]tps.State, error) {
+
+	m := make(map[string]tps.State)
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.From(dsrc.t.Name())
+	sb.Select(dsrc.f[0], dsrc.f[1])
+	q, args := sb.BuildWithFlavor(sqlbuilder.PostgreSQL)
+
+	rows, err := dbOf(dsrc.t).Query(q, args...)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key string
+			raw []byte
+			s   tps.State
+		)
+		if err := rows.Scan(&key, &raw); err != nil {
+			return m, err
+		} else if err := json.Unmarshal(raw, &s); err != nil {
+			return m, err
+		}
+		m[key] = s
+	}
+
+	return m, rows.Err()
+}
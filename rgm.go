@@ -1,17 +1,21 @@
 package rgm
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zicare/rgm/config"
 	"github.com/zicare/rgm/ds"
 	"github.com/zicare/rgm/jwt"
+	"github.com/zicare/rgm/lockout"
 	"github.com/zicare/rgm/lib"
+	"github.com/zicare/rgm/mail"
 	"github.com/zicare/rgm/msg"
 	"github.com/zicare/rgm/mw"
 	"github.com/zicare/rgm/tps"
@@ -26,10 +30,22 @@ type InitOpts struct {
 	Messages      []msg.Message
 	AclDSFactory  ds.AclDSFactory
 	Acl           ds.IDataSource
+	RoleDSFactory ds.RoleDSFactory
+	Roles         ds.IDataSource
+
+	// Optional, TPS control state is kept in-memory if not set.
+	// Set to share it among instances, i.e. mysql.TPSStoreFactory.
+	TPSStoreFactory func(dsrc ds.IDataSource) (tps.Store, error)
+	TPS             ds.IDataSource
+
+	// Optional, emails are kept in-memory until sent if not set.
+	// Set for them to survive restarts, i.e. mysql.OutboxFactory.
+	OutboxFactory func(dsrc ds.IDataSource) (mail.Outbox, error)
+	Outbox        ds.IDataSource
 }
 
 // Returns a gin.HandlersChain slice loaded with
-// mw.BasicAuthentication, mw.Abuse and h.
+// mw.BasicAuthentication, mw.SecondFactor, mw.Abuse and h.
 // h is the actual controller function.
 func BHC(fn ds.UserDSFactory, u ds.IDataSource, crypto lib.ICrypto, h gin.HandlerFunc) gin.HandlersChain {
 
@@ -41,6 +57,7 @@ func BHC(fn ds.UserDSFactory, u ds.IDataSource, crypto lib.ICrypto, h gin.Handle
 
 	handlersChain := gin.HandlersChain{}
 	handlersChain = append(handlersChain, mw.BasicAuthentication(dsrc, crypto))
+	handlersChain = append(handlersChain, mw.SecondFactor(dsrc, crypto))
 	handlersChain = append(handlersChain, mw.Abuse())
 	return append(handlersChain, h)
 }
@@ -48,29 +65,74 @@ func BHC(fn ds.UserDSFactory, u ds.IDataSource, crypto lib.ICrypto, h gin.Handle
 // Returns a gin.HandlersChain slice loaded with
 // mw.JWTAuthentication, mw.Abuse, mw.Authorization and h.
 // h is the actual controller function.
-func JHC(h gin.HandlerFunc) gin.HandlersChain {
+// scopes are the OAuth2 like scopes the token must
+// carry, on top of the role grant, to access the route.
+func JHC(h gin.HandlerFunc, scopes ...string) gin.HandlersChain {
 
 	handlersChain := gin.HandlersChain{}
 	handlersChain = append(handlersChain, mw.JWTAuthentication())
 	handlersChain = append(handlersChain, mw.Abuse())
-	handlersChain = append(handlersChain, mw.Authorization())
+	handlersChain = append(handlersChain, mw.Authorization(scopes...))
 	return append(handlersChain, h)
 }
 
+// Returns a gin.HandlersChain slice loaded with
+// auth, mw.Abuse, mw.Authorization and h.
+// auth is any authentication middleware honoring the "User"
+// context contract, i.e. mw.APIKeyAuthentication,
+// mw.HMACAuthentication or mw.CertificateAuthentication.
+// h is the actual controller function.
+// scopes are the OAuth2 like scopes the user must
+// hold, on top of the role grant, to access the route.
+func AHC(auth gin.HandlerFunc, h gin.HandlerFunc, scopes ...string) gin.HandlersChain {
+
+	handlersChain := gin.HandlersChain{}
+	handlersChain = append(handlersChain, auth)
+	handlersChain = append(handlersChain, mw.Abuse())
+	handlersChain = append(handlersChain, mw.Authorization(scopes...))
+	return append(handlersChain, h)
+}
+
+// Returns a gin.HandlersChain slice loaded with
+// mw.AnonymousAbuse and h, for unauthenticated
+// routes, i.e. the pin and signup ones.
+// h is the actual controller function.
+func PHC(h gin.HandlerFunc) gin.HandlersChain {
+
+	handlersChain := gin.HandlersChain{}
+	handlersChain = append(handlersChain, mw.AnonymousAbuse())
+	return append(handlersChain, h)
+}
+
+// Init initializes the application and starts its background
+// workers, which run for the process lifetime. Its configuration,
+// messages, ACL, TPS control and JWT revocation registry become the
+// default ones, used by the package level functions.
+// Check App for a managed lifecycle.
 func Init(opts InitOpts) error {
 
-	// Check paths
-	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	a, err := setup(opts)
 	if err != nil {
 		return err
-	} else if fi, err := os.Stat(dir + "/config"); err != nil || !fi.IsDir() {
-		return err
-	} else if fi, err := os.Stat(dir + "/certs"); err != nil || !fi.IsDir() {
-		return err
-	} else if fi, err := os.Stat(dir + "/tpl"); err != nil || !fi.IsDir() {
-		return err
-	} else if fi, err := os.Stat(dir + "/log"); err != nil || !fi.IsDir() {
+	} else if err := a.SetDefault(); err != nil {
 		return err
+	}
+
+	a.workers(context.Background())
+	return nil
+}
+
+// Loads the configuration and builds the App's state.
+func setup(opts InitOpts) (*App, error) {
+
+	a := &App{Opts: opts, done: make(chan struct{})}
+
+	// Check paths
+	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil, err
+	} else if err := isDir(dir, "config", "certs", "tpl", "log"); err != nil {
+		return nil, err
 	} else if *opts.Verbose {
 		fmt.Println("Directories: config, certs, tpl and log... OK")
 	}
@@ -78,22 +140,22 @@ func Init(opts InitOpts) error {
 	flag.Set("stderrthreshold", "FATAL")
 
 	// Config
-	if err := config.Init(*opts.Environment, dir); err != nil {
-		return err
+	if a.Config, err = config.New(*opts.Environment, dir); err != nil {
+		return nil, err
 	} else if *opts.Verbose {
 		fmt.Println("Config... OK")
 	}
 
 	// Timezone
-	if os.Setenv("TZ", config.Config().GetString("tz")); err != nil {
-		return err
+	if os.Setenv("TZ", a.Config.GetString("tz")); err != nil {
+		return nil, err
 	} else if *opts.Verbose {
 		fmt.Println("Timezone... OK")
 	}
 
 	// MSG
-	if err := msg.Init(opts.Messages); err != nil {
-		return err
+	if a.Messages, err = msg.NewCatalog(opts.Messages); err != nil {
+		return nil, err
 	} else if *opts.Verbose {
 		fmt.Println("MSG... OK")
 	}
@@ -107,26 +169,63 @@ func Init(opts InitOpts) error {
 		}
 	*/
 
-	// Load ds.Acl map in memory
+	// Load the acl map in memory
+	a.ACL = ds.NewACL()
 	if (opts.AclDSFactory == nil) || (opts.Acl == nil) {
 		fmt.Println("ACL... Not loaded")
-	} else if err := ds.Init(opts.AclDSFactory, opts.Acl); err != nil {
-		return err
+	} else if err := a.ACL.Init(opts.AclDSFactory, opts.Acl); err != nil {
+		return nil, err
 	} else if *opts.Verbose {
 		fmt.Println("ACL... OK")
 	}
 
-	// Initialize jwt.revokedJWTMap
-	jwt.Init()
+	// Load the hierarchy map in memory
+	if (opts.RoleDSFactory == nil) || (opts.Roles == nil) {
+		fmt.Println("Role hierarchy... Not loaded")
+	} else if err := a.ACL.InitRoles(opts.RoleDSFactory, opts.Roles); err != nil {
+		return nil, err
+	} else if *opts.Verbose {
+		fmt.Println("Role hierarchy... OK")
+	}
+
+	// JWT revocation registry
+	a.Revoked = jwt.NewRegistry()
+	a.Revoked.TTL, _ = time.ParseDuration(a.Config.GetString("jwt_duration"))
 	fmt.Println("JWT revokes... OK")
 
 	// Initialize tps control
-	if err = tps.Init(); err != nil {
-		return err
+	var store tps.Store
+	if (opts.TPSStoreFactory != nil) && (opts.TPS != nil) {
+		if store, err = opts.TPSStoreFactory(opts.TPS); err != nil {
+			return nil, err
+		}
+	}
+	if a.Limiter, err = tps.New(a.Config, store); err != nil {
+		return nil, err
 	} else {
 		fmt.Println("TPS control... OK")
 	}
 
+	// Initialize lockout control
+	if !a.Config.IsSet("lockout") {
+		fmt.Println("Lockout control... Not enabled")
+	} else if a.Lockout, err = lockout.New(a.Config, a.Messages); err != nil {
+		return nil, err
+	} else {
+		fmt.Println("Lockout control... OK")
+	}
+
+	// Email outbox
+	if (opts.OutboxFactory == nil) || (opts.Outbox == nil) {
+		a.Mailer = mail.New(a.Config, nil)
+		fmt.Println("Email outbox... In-memory")
+	} else if ob, err := opts.OutboxFactory(opts.Outbox); err != nil {
+		return nil, err
+	} else {
+		a.Mailer = mail.New(a.Config, ob)
+		fmt.Println("Email outbox... OK")
+	}
+
 	// Agent
 	if *opts.Verbose {
 		fmt.Println("Agent enabled..." + strconv.FormatBool(!*opts.DisableAgent))
@@ -135,5 +234,18 @@ func Init(opts InitOpts) error {
 	//  Custom validation
 	validation.Init()
 
+	return a, nil
+}
+
+// Returns an error unless every name is a directory in dir.
+func isDir(dir string, names ...string) error {
+
+	for _, n := range names {
+		if fi, err := os.Stat(dir + "/" + n); err != nil {
+			return err
+		} else if !fi.IsDir() {
+			return fmt.Errorf("%s/%s is not a directory", dir, n)
+		}
+	}
 	return nil
 }
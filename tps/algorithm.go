@@ -0,0 +1,125 @@
+package tps
+
+import (
+	"math"
+	"time"
+)
+
+// Estimator keeps the timestamps of the latest tps.precision requests.
+// Once full, each new request pops out the oldest one, and the
+// actual TPS is the number of requests divided by the time elapsed
+// since the popped one. If it exceeds max, a penalty proportional
+// to the excess, by tps.penalty_factor minutes, adds to the current one.
+// The quota window is the time tps.precision requests take at max TPS.
+func (st Settings) Estimator(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time) {
+
+	if len(s.Log) == st.Precision {
+
+		t := float32(now.UnixNano()-s.Log[0].UnixNano()) / float32(time.Second)
+		s.Log = s.Log[1:]
+
+		//Actual current tps
+		s.TPS = float32(st.Precision) / t
+
+		if s.TPS > max {
+			if s.Op == nil {
+				s.Op = &now
+			}
+			op := (*s.Op).Add(time.Minute * time.Duration(st.PenaltyFactor*(s.TPS/max)))
+			s.Op = &op
+		}
+	}
+
+	s.Log = append(s.Log, now)
+
+	// requests out of the window can be popped without exceeding max
+	w := time.Duration(float32(st.Precision) / max * float32(time.Second))
+	remaining = st.Precision - len(s.Log)
+	for _, ts := range s.Log {
+		if now.Sub(ts) >= w {
+			remaining++
+		}
+	}
+
+	return st.Precision, remaining, s.Log[len(s.Log)-1].Add(w)
+}
+
+// TokenBucket refills s.Tokens at max per second, up to tps.burst
+// seconds worth of them and no less than one. Each request takes a
+// token, and is void until the next one is available if there's none.
+// The quota is the bucket capacity.
+func (st Settings) TokenBucket(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time) {
+
+	capacity := math.Max(1, float64(max)*st.Burst.Seconds())
+
+	if s.Ts.IsZero() {
+		s.Tokens = capacity
+	} else {
+		s.Tokens = math.Min(capacity, s.Tokens+now.Sub(s.Ts).Seconds()*float64(max))
+	}
+
+	if s.Tokens >= 1 {
+		s.Tokens--
+		s.Op = nil
+	} else {
+		op := now.Add(time.Duration((1 - s.Tokens) / float64(max) * float64(time.Second)))
+		s.Op = &op
+	}
+
+	s.TPS = float32(capacity-s.Tokens) / float32(st.Burst.Seconds())
+
+	return int(capacity), int(s.Tokens), now.Add(time.Duration((capacity - s.Tokens) / float64(max) * float64(time.Second)))
+}
+
+// SlidingWindow logs the timestamps of the requests allowed within the
+// last tps.window, up to max requests per second in it and no less than
+// one. Further requests are void until the oldest one leaves the window.
+func (st Settings) SlidingWindow(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time) {
+
+	limit = int(math.Max(1, float64(max)*st.Window.Seconds()))
+
+	i := 0
+	for i < len(s.Log) && !s.Log[i].After(now.Add(-st.Window)) {
+		i++
+	}
+	s.Log = s.Log[i:]
+
+	if len(s.Log) < limit {
+		s.Log = append(s.Log, now)
+		s.Op = nil
+	} else {
+		op := s.Log[0].Add(st.Window)
+		s.Op = &op
+	}
+
+	s.TPS = float32(len(s.Log)) / float32(st.Window.Seconds())
+
+	return limit, limit - len(s.Log), s.Log[len(s.Log)-1].Add(st.Window)
+}
+
+// Estimator applies Settings.Estimator with the default Control's settings.
+func Estimator(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time) {
+
+	return settings().Estimator(s, now, max)
+}
+
+// TokenBucket applies Settings.TokenBucket with the default Control's settings.
+func TokenBucket(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time) {
+
+	return settings().TokenBucket(s, now, max)
+}
+
+// SlidingWindow applies Settings.SlidingWindow with the default Control's settings.
+func SlidingWindow(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time) {
+
+	return settings().SlidingWindow(s, now, max)
+}
+
+// Returns the default Control's settings, zero if disabled.
+func settings() Settings {
+
+	if control == nil {
+		return Settings{}
+	}
+	return control.Settings
+}
@@ -18,3 +18,8 @@ type CleanUpCycleRange struct {
 type PenaltyFactorRange struct {
 	msg.Message
 }
+
+// UnknownAlgorithm exported
+type UnknownAlgorithm struct {
+	msg.Message
+}
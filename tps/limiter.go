@@ -0,0 +1,132 @@
+package tps
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Limiter accounts for transactions by key.
+type Limiter interface {
+
+	// Transaction takes note of a request for key, limited to max TPS,
+	// and returns key's Status. Status.Op is the time before which requests
+	// for key are void, nil if the limit is not exceeded.
+	// A max of 0 or less means no limit.
+	Transaction(key string, max float32) Status
+}
+
+// Status of a key after a transaction.
+type Status struct {
+
+	// Requests allowed per quota window.
+	Limit int
+
+	// Requests left in the current quota window.
+	Remaining int
+
+	// Time the quota is fully restored.
+	Reset time.Time
+
+	// The penalty, nil if none.
+	Op *time.Time
+
+	// Latest TPS estimate.
+	TPS float32
+}
+
+// State is the transaction record of a key.
+type State struct {
+
+	// Timestamps of the latest requests, oldest first.
+	Log []time.Time `json:"log,omitempty"`
+
+	// Tokens left in the bucket.
+	Tokens float64 `json:"tokens,omitempty"`
+
+	// Latest request timestamp.
+	Ts time.Time `json:"ts"`
+
+	// The penalty. Requests won't be authorized before this time.
+	// A nil value means no penalty.
+	Op *time.Time `json:"op,omitempty"`
+
+	// The penalty set with Penalize, i.e. by an admin. Unlike Op,
+	// algorithms never lift it. A nil value means no such penalty.
+	Ban *time.Time `json:"ban,omitempty"`
+
+	// Latest TPS estimate.
+	TPS float32 `json:"tps"`
+
+	// TPS quota overriding the one requested, until QuotaUntil.
+	Quota      float32    `json:"quota,omitempty"`
+	QuotaUntil *time.Time `json:"quota_until,omitempty"`
+}
+
+// Penalty returns the latest of s.Op and s.Ban pending at now,
+// nil if none.
+func (s State) Penalty(now time.Time) *time.Time {
+
+	var p *time.Time
+	for _, t := range []*time.Time{s.Op, s.Ban} {
+		if t != nil && t.After(now) && (p == nil || t.After(*p)) {
+			p = t
+		}
+	}
+	return p
+}
+
+// Returns the quota overriding max at now, if any.
+func (s State) quota(now time.Time, max float32) float32 {
+
+	if s.QuotaUntil != nil && now.Before(*s.QuotaUntil) {
+		return s.Quota
+	}
+	return max
+}
+
+// Algorithm accounts for a request made at now to s,
+// limited to max TPS, setting s.Op if exceeded.
+// It returns the requests allowed per quota window, the ones
+// left and the time the quota is fully restored.
+type Algorithm func(s *State, now time.Time, max float32) (limit, remaining int, reset time.Time)
+
+// Limiter applying alg to the States in store.
+// Quotas overridden in a State take precedence.
+type storeLimiter struct {
+	store Store
+	alg   Algorithm
+}
+
+// NewLimiter returns a Limiter applying alg to the States in store.
+func NewLimiter(alg Algorithm, store Store) Limiter {
+
+	return &storeLimiter{store: store, alg: alg}
+}
+
+// Store errors are logged, and the request let through.
+// Keys banned with Penalize are void with no further accounting.
+func (l *storeLimiter) Transaction(key string, max float32) (st Status) {
+
+	if max <= 0 {
+		return st
+	}
+
+	now := time.Now()
+	err := l.store.Update(key, func(s *State) {
+		if s.Ban != nil && s.Ban.After(now) {
+			st.Op, st.TPS = s.Ban, s.TPS
+			return
+		}
+		st.Limit, st.Remaining, st.Reset = l.alg(s, now, s.quota(now, max))
+		s.Ts = now
+		st.Op, st.TPS = s.Op, s.TPS
+	})
+
+	if err != nil {
+		glog.Error(err)
+		glog.Flush()
+		return Status{}
+	}
+	return st
+}
@@ -0,0 +1,97 @@
+package tps
+
+import (
+	"time"
+
+	"github.com/zicare/rgm/ds"
+	"github.com/zicare/rgm/lib"
+)
+
+// Rule limits the requests to the routes matching Route, check ds.Match,
+// and Method, "*" for any, made by users holding Role, any if empty,
+// or a role inheriting from it, check ds.Hierarchy.
+// Each user, or client IP if anonymous, gets a bucket per rule,
+// on top of its own TPS quota.
+type Rule struct {
+	Route  string  `json:"route" mapstructure:"route"`
+	Method string  `json:"method" mapstructure:"method"`
+	Role   string  `json:"role" mapstructure:"role"`
+	TPS    float32 `json:"tps" mapstructure:"tps"`
+}
+
+// AddRule adds r to the rules in force in the default Control.
+func AddRule(r Rule) {
+
+	control.AddRule(r)
+}
+
+// AddRule adds r to the rules in force,
+// i.e. when registering a route.
+func (c *Control) AddRule(r Rule) {
+
+	c.rulesMu.Lock()
+	defer c.rulesMu.Unlock()
+
+	c.rules = append(c.rules, r)
+}
+
+// Returns the rules applying to a request to route and method
+// made by a user holding roles, or anonymous if none.
+// roles are expected to include the inherited ones.
+func (c *Control) matching(route, method string, roles []string) (rs []Rule) {
+
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
+	for _, r := range c.rules {
+		if r.Role != "" && !lib.Contains(roles, r.Role) {
+			continue
+		} else if (ds.Grant{Route: r.Route, Method: r.Method}).Matches(route, method) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// Check accounts for the request in the default Control.
+func Check(subject string, max float32, route, method string, roles []string) Status {
+
+	return control.Check(subject, max, route, method, roles)
+}
+
+// Check accounts for a request to route and method made by subject,
+// a user's key or a client IP, limited to max TPS, and to the rules
+// matching the route, method and subject's roles, the inherited ones
+// included, check ds.ACL.Effective. It returns the most restrictive
+// Status, check Transaction.
+func (c *Control) Check(subject string, max float32, route, method string, roles []string) Status {
+
+	st := c.limiter.Transaction(subject, max)
+	for _, r := range c.matching(route, method, roles) {
+		st = worst(st, c.limiter.Transaction(Key(subject, r.Method, r.Route, r.Role), r.TPS))
+	}
+	return st
+}
+
+// Returns the most restrictive of a and b,
+// the one voiding access the longest if any.
+func worst(a, b Status) Status {
+
+	now := time.Now()
+	ao := a.Op != nil && a.Op.After(now)
+	bo := b.Op != nil && b.Op.After(now)
+
+	if ao && bo {
+		if b.Op.After(*a.Op) {
+			return b
+		}
+		return a
+	} else if ao {
+		return a
+	} else if bo || a.Limit == 0 {
+		return b
+	} else if b.Limit > 0 && b.Remaining < a.Remaining {
+		return b
+	}
+	return a
+}
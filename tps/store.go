@@ -0,0 +1,94 @@
+package tps
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps the State of every limiter key.
+// Stores shared by several instances, i.e. SQL backed ones,
+// make them enforce a common quota per key.
+type Store interface {
+
+	// Update applies fn to the State of key, a zero State
+	// if there's none, and saves it, atomically.
+	Update(key string, fn func(s *State)) error
+
+	// Purge removes the States with no requests since t
+	// and no penalty pending at t.
+	Purge(t time.Time) error
+
+	// List returns the States by key.
+	List() (map[string]State, error)
+}
+
+// Idle returns the time since which s is neither
+// accounting requests, voiding them nor overriding its quota.
+func (s State) Idle() time.Time {
+
+	idle := s.Ts
+	if s.Op != nil && s.Op.After(idle) {
+		idle = *s.Op
+	}
+	if s.Ban != nil && s.Ban.After(idle) {
+		idle = *s.Ban
+	}
+	if s.QuotaUntil != nil && s.QuotaUntil.After(idle) {
+		idle = *s.QuotaUntil
+	}
+	return idle
+}
+
+// In-memory Store, guarded by mu.
+type memStore struct {
+	mu sync.Mutex
+	m  map[string]*State
+}
+
+// NewMemStore returns an in-memory Store, the default one.
+func NewMemStore() Store {
+
+	return &memStore{m: map[string]*State{}}
+}
+
+func (ms *memStore) Update(key string, fn func(s *State)) error {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.m[key]
+	if !ok {
+		s = new(State)
+		ms.m[key] = s
+	}
+	fn(s)
+
+	return nil
+}
+
+func (ms *memStore) Purge(t time.Time) error {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for k, s := range ms.m {
+		if s.Idle().Before(t) {
+			delete(ms.m, k)
+		}
+	}
+
+	return nil
+}
+
+func (ms *memStore) List() (map[string]State, error) {
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	m := make(map[string]State, len(ms.m))
+	for k, s := range ms.m {
+		m[k] = *s
+	}
+
+	return m, nil
+}
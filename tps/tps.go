@@ -1,174 +1,379 @@
 // TPS package.
 // TPS is an acronym for Transactions Per Second package.
 // This package allows for TPS control.
-// Users's actual TPS is recalculated on every request and a time penalty
-// is accounted for in case the user's TPS rate is exceeded.
-// Penalties are accumulative, so if a user who is currently voided insists
-// making new requests exceeding her TPS quota, new penalties will add to
-// existing ones.
-// Penalties are stored in-memory in the TPSmap registry. Obsolete penalties
-// are removed from the registry automatically.
+// Every request is accounted for by a Limiter, which tells whether
+// the user's TPS quota is exceeded, and until when access is void.
+// Three algorithms are available, set with tps.algorithm:
+//
+// "estimator", the default. The user's actual TPS is recalculated on
+// every request and a time penalty is accounted for in case the user's
+// TPS rate is exceeded. Penalties are accumulative, so if a user who is
+// currently voided insists making new requests exceeding her TPS quota,
+// new penalties will add to existing ones.
+//
+// "token_bucket". Tokens are refilled at the TPS rate, up to tps.burst
+// seconds worth of them. Each request takes one, and is rejected while
+// the bucket is empty.
+//
+// "sliding_window". Up to TPS * tps.window requests are allowed within
+// any tps.window, tracking each request's timestamp.
+//
+// Limits per route, method and role can be set with Rule's, and
+// unauthenticated requests are limited per client IP to tps.anonymous.
+//
+// State is kept in a Store, in-memory by default, or shared by several
+// instances, i.e. SQL backed, for them to enforce a common quota.
+// Obsolete entries are removed by CleanUp.
+// Limiters are safe for concurrent use.
+//
+// The package level functions work on the default Control, set by Init.
+// Use New for independent ones.
 package tps
 
 import (
+	"context"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
 	"github.com/zicare/rgm/config"
+	"github.com/zicare/rgm/msg"
 )
 
-// TPS exported
-type TPS struct {
+// Control is a TPS control instance, a Limiter along
+// its Store, Settings and Rule's. Controls are safe for
+// concurrent use.
+type Control struct {
+	Settings
 
-	// A buffered channel, used to calculate the actual TPS.
-	// A channel capacity value must be set in the configuration file.
-	// The requests' timestamps are sent to the channel.
-	// When the channel is full, difference in seconds from
-	// oldest to newest timestamps divided by the channel
-	// capacity will tell the actual TPS.
-	// Once the channel is full, each new request pops out the oldest one
-	// before recalculating the TPS and possibly a penalty.
-	// The channel capacity must be 3 or more.
-	ch chan time.Time
+	limiter Limiter
+	store   Store
 
-	// Channel more recent update timestamp
-	// The newest timestamp in the channel matches this value
-	// Used by the cleanUp go-routine.
-	ts time.Time
+	// Rules loaded from tps.rules on New, and added with AddRule.
+	rules   []Rule
+	rulesMu sync.RWMutex
 
-	// The penalty. Requests won't be authorized before this time.
-	// A nil value means no penalty.
-	op *time.Time
+	// Windows accounted for by Limit, kept in-memory.
+	windows       map[string]*window
+	windowsPurged time.Time
+	windowsMu     sync.Mutex
 }
 
-// TPSmap exported
-type TPSmap map[string]map[string]*TPS
+// A fixed window of Limit, from its first use.
+type window struct {
+	end   time.Time
+	count int
+}
+
+// Settings of a Control, loaded from the tps.* configuration settings.
+type Settings struct {
+
+	// Number of requests needed to measure actual TPS.
+	Precision int
+
+	// Clean up cycle length, in minutes.
+	CleanUpCycle time.Duration
+
+	// Penalty factor
+	PenaltyFactor float32
+
+	// Token bucket burst and sliding window length
+	Burst, Window time.Duration
 
-// The map.
-// Each acl.User required to authorize a transaction
-// have an entry on this map.
-// Lifetime of an entry with no new transactions is determined by mclnup.
-var tpsmap TPSmap
+	// TPS quota of anonymous requests, per client IP.
+	Anonymous float32
+}
+
+// The default Control set by Init, nil if TPS control is disabled.
+var control *Control
 
-// Number of requests needed to measure actual TPS.
-// This imposses the channel capacity.
-var chcap int
+// Init function initializes the default TPS control, check New.
+func Init(s Store) error {
 
-// TPS map clean up cycle length
-var mclnup time.Duration
+	c, err := New(config.Config(), s)
+	if err != nil {
+		return err
+	}
 
-// Penalty factor
-var pf float32
+	control = c
+	return nil
+}
 
-// Init function initializes TPS control.
-// chcap is the number of request needed to calculate TPS.
+// New returns a TPS control with the tps.* settings in cf,
+// keeping state in store, or in-memory if nil.
+// precision is the number of request needed to calculate TPS.
 // After a period of a user's inactivity, her TPS control is reset
-// to save server resources. mapcln param sets the time window of
-// inactivity required in order to reset. mapcln starts counting
+// to save server resources. clean_up_cycle sets the time window, in
+// minutes, of inactivity required in order to reset. It starts counting
 // after any TPS related penalty is fulfilled.
-func Init() error {
-
-	chcap = config.Config().GetInt("tps.precision")
-	mclnup = config.Config().GetDuration("tps.clean_up_cycle")
-	pf = float32(config.Config().GetFloat64("tps.penalty_factor"))
-
-	if (chcap < 3) || (chcap > 10) {
-		e := new(PrecisionRange).SetArgs("3", "10")
-		return &e
-	} else if (mclnup < 1) || (mclnup > 10) {
-		e := new(CleanUpCycleRange).SetArgs("1", "10")
-		return &e
-	} else if (pf < 0) || (pf > 10) {
-		e := new(PenaltyFactorRange).SetArgs("0", "10")
-		return &e
+func New(cf *viper.Viper, s Store) (*Control, error) {
+
+	c := &Control{
+		Settings: Settings{
+			Precision:     cf.GetInt("tps.precision"),
+			CleanUpCycle:  cf.GetDuration("tps.clean_up_cycle"),
+			PenaltyFactor: float32(cf.GetFloat64("tps.penalty_factor")),
+			Burst:         cf.GetDuration("tps.burst"),
+			Window:        cf.GetDuration("tps.window"),
+			Anonymous:     float32(cf.GetFloat64("tps.anonymous")),
+		},
+		windows: map[string]*window{},
+	}
+
+	if c.Burst <= 0 {
+		c.Burst = time.Second
+	}
+	if c.Window <= 0 {
+		c.Window = time.Second
+	}
+
+	alg, ok := algorithms[cf.GetString("tps.algorithm")]
+
+	if err := cf.UnmarshalKey("tps.rules", &c.rules); err != nil {
+		return nil, err
+	}
+
+	if (c.Precision < 3) || (c.Precision > 10) {
+		return nil, &PrecisionRange{msg.Get("20").SetArgs("3", "10")}
+	} else if (c.CleanUpCycle < 1) || (c.CleanUpCycle > 10) {
+		return nil, &CleanUpCycleRange{msg.Get("21").SetArgs("1", "10")}
+	} else if (c.PenaltyFactor < 0) || (c.PenaltyFactor > 10) {
+		return nil, &PenaltyFactorRange{msg.Get("70").SetArgs("0", "10")}
+	} else if !ok {
+		return nil, &UnknownAlgorithm{msg.Get("71").SetArgs(cf.GetString("tps.algorithm"))}
 	} else {
-		tpsmap = map[string]map[string]*TPS{}
-		cleanUp()
-		return nil
+		if c.store = s; c.store == nil {
+			c.store = NewMemStore()
+		}
+		c.limiter = NewLimiter(alg(c.Settings), c.store)
+		return c, nil
+	}
+}
+
+// Default returns the Control the package level
+// functions work on, nil if TPS control is disabled.
+func Default() *Control {
+
+	return control
+}
+
+// SetDefault sets c as the Control the package level functions
+// work on. A nil c disables TPS control.
+func SetDefault(c *Control) {
+
+	control = c
+}
+
+// Of returns the Control set in the "TPS" context key,
+// i.e. by rgm.App, or the default one.
+func Of(c *gin.Context) *Control {
+
+	if v, exists := c.Get("TPS"); !exists {
+		return control
+	} else if v, ok := v.(*Control); ok {
+		return v
 	}
+	return control
+}
+
+// Algorithms by tps.algorithm setting.
+var algorithms = map[string]func(st Settings) Algorithm{
+	"":               func(st Settings) Algorithm { return st.Estimator },
+	"estimator":      func(st Settings) Algorithm { return st.Estimator },
+	"token_bucket":   func(st Settings) Algorithm { return st.TokenBucket },
+	"sliding_window": func(st Settings) Algorithm { return st.SlidingWindow },
+}
+
+// Key returns the limiter key for parts, i.e. a user type and uid.
+func Key(parts ...string) string {
+
+	return strings.Join(parts, "|")
+}
+
+// Transaction accounts for the request in the default Control.
+func Transaction(t string, uid string, tpsMax float32) Status {
+
+	return control.Transaction(t, uid, tpsMax)
 }
 
 // Transaction takes note of the request,
-// recalculates the actual TPS, and returns a datetime
-// if the TPS rate is exceeded. In such case, the user
-// shouldn't be granted access before said datetime.
+// recalculates the actual TPS, and returns the user's Status.
+// If the TPS rate is exceeded, Status.Op is set, and the
+// user shouldn't be granted access before said datetime.
 // Even if a user is blocked, new request should also be
 // accounted for here, a more distant datetime could be returned.
-func Transaction(t string, uid string, tpsMax float32) *time.Time {
+func (c *Control) Transaction(t string, uid string, tpsMax float32) Status {
 
-	now := time.Now()
+	return c.limiter.Transaction(Key(t, uid), tpsMax)
+}
 
-	if _, ok := tpsmap[t]; !ok {
-		tpsmap[t] = map[string]*TPS{}
-	}
+// Anonymous accounts for the request in the default Control.
+func Anonymous(ip string, route, method string) Status {
+
+	return control.Anonymous(ip, route, method)
+}
+
+// Anonymous accounts for an unauthenticated request to route and method
+// made from ip, limited to tps.anonymous TPS and to the rules with no role
+// matching the route and method. It returns the most restrictive Status.
+func (c *Control) Anonymous(ip string, route, method string) Status {
+
+	return c.Check(Key("ip", ip), c.Settings.Anonymous, route, method, nil)
+}
 
-	if _, ok := tpsmap[t][uid]; !ok {
-		tps := new(TPS)
-		tps.ch = make(chan time.Time, chcap)
-		tpsmap[t][uid] = tps
+// List returns the States in the default Control.
+func List() (map[string]State, error) {
+
+	return control.List()
+}
+
+// List returns the State of every key in the store,
+// that is users and client IPs currently accounted for.
+func (c *Control) List() (map[string]State, error) {
+
+	return c.store.List()
+}
+
+// Penalize voids access for key in the default Control.
+func Penalize(key string, until *time.Time) error {
+
+	return control.Penalize(key, until)
+}
+
+// Penalize voids access for key until the given time, regardless
+// of the requests made meanwhile, replacing its current ban, check
+// State.Ban. A nil until lifts both the ban and the penalty set by
+// the algorithm, resetting key's request record as well.
+func (c *Control) Penalize(key string, until *time.Time) error {
+
+	return c.store.Update(key, func(s *State) {
+		if until == nil {
+			*s = State{Quota: s.Quota, QuotaUntil: s.QuotaUntil}
+		} else {
+			s.Ban = until
+		}
+	})
+}
+
+// Clear lifts the penalties of key in the default Control.
+func Clear(key string) (bool, error) {
+
+	return control.Clear(key)
+}
+
+// Clear lifts key's ban and penalty, resetting its request record,
+// along with the ones of the rule buckets keyed by it, check Check.
+// Keys not in the store are skipped, false is returned if none is.
+func (c *Control) Clear(key string) (bool, error) {
+
+	m, err := c.store.List()
+	if err != nil {
+		return false, err
 	}
 
-	if len(tpsmap[t][uid].ch) == cap(tpsmap[t][uid].ch) {
-		tpsmap[t][uid].setOp(<-tpsmap[t][uid].ch, now, tpsMax)
+	found := false
+	for k := range m {
+		if k != key && !strings.HasPrefix(k, key+"|") {
+			continue
+		} else if err := c.Penalize(k, nil); err != nil {
+			return found, err
+		}
+		found = true
 	}
+	return found, nil
+}
 
-	tpsmap[t][uid].ch <- now
-	tpsmap[t][uid].ts = now
+// Override sets key's TPS quota in the default Control.
+func Override(key string, quota float32, until time.Time) error {
 
-	return tpsmap[t][uid].op
+	return control.Override(key, quota, until)
 }
 
-// Return actual current tps and
-// Sets tps.op to block transactions if max tps limit is exceeded
-func (tps *TPS) setOp(tch time.Time, now time.Time, tpsMax float32) float32 {
+// Override sets quota as key's TPS quota until the given time,
+// instead of the user's one or the rule's one.
+// Unlimited users are not affected.
+func (c *Control) Override(key string, quota float32, until time.Time) error {
+
+	return c.store.Update(key, func(s *State) {
+		s.Quota, s.QuotaUntil = quota, &until
+	})
+}
 
-	t := float32(now.UnixNano()-tch.UnixNano()) / float32(time.Second)
+// Limit accounts for a use of key in the default Control.
+func Limit(key string, n int, d time.Duration) *time.Time {
+
+	return control.Limit(key, n, d)
+}
 
-	//Actual current tps
-	tpsNow := float32(chcap) / t
+// Limit accounts for a use of key, allowed n times within a window
+// of length d starting on its first use, i.e. sign-in links requested
+// per email, or a nonce allowed once. It returns the time the window
+// ends if the use exceeds n, nil otherwise.
+// Windows are kept in-memory, apart from the store, and the ended
+// ones are removed once every clean up cycle.
+func (c *Control) Limit(key string, n int, d time.Duration) *time.Time {
 
-	if tpsNow > tpsMax {
-		if tps.op == nil {
-			tps.op = &now
+	c.windowsMu.Lock()
+	defer c.windowsMu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(c.windowsPurged) > c.CleanUpCycle*time.Minute {
+		for k, w := range c.windows {
+			if now.After(w.end) {
+				delete(c.windows, k)
+			}
 		}
-		op := (*tps.op).Add(time.Minute * time.Duration(pf*(tpsNow/tpsMax)))
-		tps.op = &op
+		c.windowsPurged = now
 	}
 
-	return tpsNow
-}
+	w, ok := c.windows[key]
+	if !ok || now.After(w.end) {
+		w = &window{end: now.Add(d)}
+		c.windows[key] = w
+	}
 
-// IsEnabled exported.
-// Just by calling Init() once, IsEnabled will return true.
-func IsEnabled() bool {
-	if tpsmap != nil {
-		return true
+	if w.count >= n {
+		until := w.end
+		return &until
 	}
-	return false
+	w.count++
+	return nil
+}
+
+// CleanUp cleans the default Control's store up, until ctx is done.
+func CleanUp(ctx context.Context) {
+
+	control.CleanUp(ctx)
 }
 
-// Remove obsolete entries from the TPSmap registry to save resources.
-// The registry is inspected with a frequency determined by mclnup.
-// mclnup is the wait in minutes for a new clean up cycle to start
-// after the previous cycle ended.
-// mclnup must be set in the configuration file.
+// CleanUp removes obsolete entries from the store to save resources,
+// every clean up cycle, until ctx is done.
 // An entry is considered obsolete if 2 conditions are met.
-// 1. The more recent channel update (TPS.ts), that is the last request,
-// was made at least 2 clean up cycles ago.
+// 1. The last request was made at least a clean up cycle ago.
 // 2. The penalty is nil or fulfilled
-func cleanUp() {
-
-	go func(m TPSmap) {
-		d := mclnup * time.Minute
-		for {
-			for _, v1 := range m {
-				for k2, v2 := range v1 {
-					if v2.ts.Before(time.Now().Add(-1*d)) &&
-						(v2.op == nil || v2.op.Before(time.Now())) {
-						delete(v1, k2)
-					}
-				}
-			}
-			time.Sleep(d)
+func (c *Control) CleanUp(ctx context.Context) {
+
+	d := c.CleanUpCycle * time.Minute
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
 		}
-	}(tpsmap)
+		if err := c.store.Purge(time.Now().Add(-1 * d)); err != nil {
+			glog.Error(err)
+			glog.Flush()
+		}
+	}
+}
+
+// IsEnabled exported.
+// Just by calling Init() once, IsEnabled will return true.
+func IsEnabled() bool {
+
+	return control != nil
 }
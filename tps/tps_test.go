@@ -0,0 +1,308 @@
+package tps
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestEstimator(t *testing.T) {
+
+	st := Settings{Precision: 3, PenaltyFactor: 1}
+	s := &State{}
+
+	// the first precision requests only fill the log
+	for i := 0; i < 3; i++ {
+		st.Estimator(s, t0.Add(time.Duration(i)*10*time.Millisecond), 1)
+		if s.Op != nil {
+			t.Fatalf("request %d: unexpected penalty %v", i, s.Op)
+		}
+	}
+
+	// 3 requests in 30ms is 100 TPS, 100 times the quota
+	now := t0.Add(30 * time.Millisecond)
+	st.Estimator(s, now, 1)
+	if s.Op == nil {
+		t.Fatal("expected a penalty")
+	} else if want := now.Add(100 * time.Minute); !s.Op.Equal(want) {
+		t.Fatalf("penalty until %v, want %v", s.Op, want)
+	}
+
+	// penalties add up
+	op := *s.Op
+	st.Estimator(s, now.Add(10*time.Millisecond), 1)
+	if !s.Op.After(op) {
+		t.Fatalf("penalty %v not extended past %v", s.Op, op)
+	}
+}
+
+func TestEstimatorWithinQuota(t *testing.T) {
+
+	st := Settings{Precision: 3, PenaltyFactor: 1}
+	s := &State{}
+
+	for i := 0; i < 10; i++ {
+		st.Estimator(s, t0.Add(time.Duration(i)*time.Second), 2)
+	}
+	if s.Op != nil {
+		t.Fatalf("unexpected penalty %v at %v TPS", s.Op, s.TPS)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+
+	st := Settings{Burst: time.Second}
+	s := &State{}
+
+	// a full bucket holds 2 tokens
+	for i := 0; i < 2; i++ {
+		limit, remaining, _ := st.TokenBucket(s, t0, 2)
+		s.Ts = t0
+		if s.Op != nil {
+			t.Fatalf("request %d: unexpected penalty %v", i, s.Op)
+		} else if limit != 2 || remaining != 1-i {
+			t.Fatalf("request %d: limit %d remaining %d", i, limit, remaining)
+		}
+	}
+
+	// empty, void until a token is refilled
+	st.TokenBucket(s, t0, 2)
+	s.Ts = t0
+	if s.Op == nil {
+		t.Fatal("expected a penalty")
+	} else if want := t0.Add(500 * time.Millisecond); !s.Op.Equal(want) {
+		t.Fatalf("penalty until %v, want %v", s.Op, want)
+	}
+
+	// refilled
+	st.TokenBucket(s, t0.Add(500*time.Millisecond), 2)
+	if s.Op != nil {
+		t.Fatalf("unexpected penalty %v once refilled", s.Op)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+
+	st := Settings{Window: time.Second}
+	s := &State{}
+
+	for i := 0; i < 2; i++ {
+		st.SlidingWindow(s, t0.Add(time.Duration(i)*100*time.Millisecond), 2)
+		if s.Op != nil {
+			t.Fatalf("request %d: unexpected penalty %v", i, s.Op)
+		}
+	}
+
+	// the window is full until the first request leaves it
+	limit, remaining, _ := st.SlidingWindow(s, t0.Add(200*time.Millisecond), 2)
+	if s.Op == nil {
+		t.Fatal("expected a penalty")
+	} else if want := t0.Add(time.Second); !s.Op.Equal(want) {
+		t.Fatalf("penalty until %v, want %v", s.Op, want)
+	} else if limit != 2 || remaining != 0 {
+		t.Fatalf("limit %d remaining %d", limit, remaining)
+	}
+
+	st.SlidingWindow(s, t0.Add(time.Second+time.Millisecond), 2)
+	if s.Op != nil {
+		t.Fatalf("unexpected penalty %v once the window slid", s.Op)
+	}
+}
+
+func TestPenalizeOutlastsAlgorithm(t *testing.T) {
+
+	c := newControl(t, "token_bucket")
+
+	until := time.Now().Add(time.Hour)
+	if err := c.Penalize(Key("u", "1"), &until); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket is full, yet the ban holds
+	if st := c.Transaction("u", "1", 100); st.Op == nil || !st.Op.Equal(until) {
+		t.Fatalf("penalty %v, want %v", st.Op, until)
+	}
+
+	if err := c.Penalize(Key("u", "1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Transaction("u", "1", 100); st.Op != nil {
+		t.Fatalf("unexpected penalty %v once lifted", st.Op)
+	}
+}
+
+func TestClear(t *testing.T) {
+
+	c := newControl(t, "token_bucket")
+	c.AddRule(Rule{Route: "/items", Method: "GET", TPS: 1})
+
+	// the rule's bucket holds a single token
+	c.Check(Key("u", "1"), 100, "/items", "GET", nil)
+	if st := c.Check(Key("u", "1"), 100, "/items", "GET", nil); st.Op == nil {
+		t.Fatal("expected a penalty")
+	}
+
+	if found, err := c.Clear(Key("u", "1")); err != nil {
+		t.Fatal(err)
+	} else if !found {
+		t.Fatal("key not found")
+	} else if st := c.Check(Key("u", "1"), 100, "/items", "GET", nil); st.Op != nil {
+		t.Fatalf("unexpected penalty %v once cleared", st.Op)
+	}
+
+	// unknown keys are not added
+	if found, err := c.Clear(Key("u", "2")); err != nil {
+		t.Fatal(err)
+	} else if found {
+		t.Fatal("unknown key found")
+	} else if m, _ := c.List(); len(m) != 2 {
+		t.Fatalf("%d keys in the store, want 2", len(m))
+	}
+}
+
+func TestLimit(t *testing.T) {
+
+	c := newControl(t, "")
+	k := Key("magic", "a@b.c")
+
+	for i := 0; i < 3; i++ {
+		if until := c.Limit(k, 3, 50*time.Millisecond); until != nil {
+			t.Fatalf("use %d limited until %v", i, until)
+		}
+	}
+	if until := c.Limit(k, 3, 50*time.Millisecond); until == nil {
+		t.Fatal("expected a limit")
+	}
+
+	// other keys and other Controls are not affected
+	if until := c.Limit(Key("magic", "d@e.f"), 3, 50*time.Millisecond); until != nil {
+		t.Fatal("unexpected limit of another key")
+	} else if until := newControl(t, "").Limit(k, 3, 50*time.Millisecond); until != nil {
+		t.Fatal("unexpected limit in another Control")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if until := c.Limit(k, 3, 50*time.Millisecond); until != nil {
+		t.Fatal("unexpected limit once the window ended")
+	}
+}
+
+func TestLimiterConcurrent(t *testing.T) {
+
+	var (
+		l       = NewLimiter(Settings{Window: time.Hour}.SlidingWindow, NewMemStore())
+		allowed int32
+		wg      sync.WaitGroup
+	)
+
+	// 10 requests fit in the window
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if st := l.Transaction("k", float32(10)/3600); st.Op == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 10 {
+		t.Fatalf("%d requests allowed, want 10", allowed)
+	}
+}
+
+func TestMemStorePurge(t *testing.T) {
+
+	var (
+		st = NewMemStore()
+		op = t0.Add(time.Hour)
+		n  int
+	)
+
+	st.Update("idle", func(s *State) { s.Ts = t0 })
+	st.Update("void", func(s *State) { s.Ts, s.Op = t0, &op })
+	if err := st.Purge(t0.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a purged key starts over with a zero State
+	st.Update("idle", func(s *State) {
+		if !s.Ts.IsZero() {
+			t.Error("idle key not purged")
+		}
+	})
+	st.Update("void", func(s *State) {
+		if s.Op == nil {
+			t.Error("key with a pending penalty purged")
+		}
+		n++
+	})
+	if n != 1 {
+		t.Fatalf("update applied %d times, want 1", n)
+	}
+}
+
+// Returns a Control using alg, with an in-memory store.
+func newControl(t testing.TB, alg string) *Control {
+
+	cf := viper.New()
+	cf.Set("tps.precision", 5)
+	cf.Set("tps.clean_up_cycle", 1)
+	cf.Set("tps.penalty_factor", 1)
+	cf.Set("tps.algorithm", alg)
+
+	c, err := New(cf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func benchmarkTransaction(b *testing.B, alg string) {
+
+	c := newControl(b, alg)
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		uid := strconv.FormatInt(atomic.AddInt64(&n, 1), 10)
+		for pb.Next() {
+			c.Transaction("u", uid, 1000)
+		}
+	})
+}
+
+func BenchmarkEstimator(b *testing.B) {
+
+	benchmarkTransaction(b, "estimator")
+}
+
+func BenchmarkTokenBucket(b *testing.B) {
+
+	benchmarkTransaction(b, "token_bucket")
+}
+
+func BenchmarkSlidingWindow(b *testing.B) {
+
+	benchmarkTransaction(b, "sliding_window")
+}
+
+// All goroutines hitting a single key, i.e. one user.
+func BenchmarkSharedKey(b *testing.B) {
+
+	c := newControl(b, "token_bucket")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Transaction("u", "1", 1000)
+		}
+	})
+}